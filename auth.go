@@ -0,0 +1,75 @@
+package eventsource
+
+import (
+	"net/http"
+	"time"
+)
+
+// Authorizer interface is used to accept or reject a connection before it is
+// hijacked, given the channels the client asks to subscribe to. An authorizer
+// rejects a connection by returning an error; a StatusError sets the status
+// code and message of the response, any other error is answered with 403
+// Forbidden. This package has three built-in implementations:
+// NoAuthorization, PrivateChannels and JWTAuthorizer, but you can implement
+// your own.
+type Authorizer interface {
+	Authorize(req *http.Request, channels []string) (Principal, error)
+}
+
+// A Principal is the authenticated user behind a client, as returned by an
+// Authorizer. When its ID is not empty, it is used as the client identity
+// instead of the one found by the IdentityResolver. When Expires is not zero,
+// the client is disconnected once it passes, with a close event whose reason
+// is ExpiredError.
+type Principal struct {
+	ID         string
+	Attributes map[string]string
+	Expires    time.Time
+}
+
+// ExpiredError is the reason of the close event sent to clients whose
+// principal has expired.
+var ExpiredError = "session expired"
+
+// NoAuthorization implements the Authorizer interface by accepting every
+// connection with an anonymous principal.
+type NoAuthorization struct{}
+
+// Authorize accepts the connection.
+func (NoAuthorization) Authorize(*http.Request, []string) (Principal, error) {
+	return Principal{}, nil
+}
+
+// Unauthorized returns an error rejecting a connection without valid
+// credentials with 401 Unauthorized.
+func Unauthorized(message string) error {
+	return StatusError{http.StatusUnauthorized, message}
+}
+
+// Forbidden returns an error rejecting a connection not allowed to subscribe to
+// its channels with 403 Forbidden.
+func Forbidden(message string) error {
+	return StatusError{http.StatusForbidden, message}
+}
+
+// authorize consults the authorizer about a new client, attaching the
+// principal to it. Errors other than a StatusError are turned into 403
+// Forbidden.
+func (es *Eventsource) authorize(req *http.Request, c *client) error {
+	authorizer := es.Authorizer
+	if authorizer == nil {
+		authorizer = NoAuthorization{}
+	}
+	principal, err := authorizer.Authorize(req, c.channels)
+	if err != nil {
+		if _, ok := err.(StatusError); !ok {
+			err = Forbidden(err.Error())
+		}
+		return err
+	}
+	c.principal = principal
+	if principal.ID != "" {
+		c.identity = principal.ID
+	}
+	return nil
+}
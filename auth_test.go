@@ -0,0 +1,91 @@
+package eventsource
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type authorizerFunc func(*http.Request, []string) (Principal, error)
+
+func (f authorizerFunc) Authorize(req *http.Request, channels []string) (Principal, error) {
+	return f(req, channels)
+}
+
+func TestNoAuthorization(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	principal, err := NoAuthorization{}.Authorize(req, []string{"a"})
+	if err != nil || principal.ID != "" {
+		t.Errorf("expected anonymous principal\ngot:\n%v %v\n", principal, err)
+	}
+}
+
+func TestEventsourceAuthorize(t *testing.T) {
+	es := Eventsource{Authorizer: authorizerFunc(func(req *http.Request, channels []string) (Principal, error) {
+		return Principal{ID: "alice", Attributes: map[string]string{"role": "admin"}}, nil
+	})}
+	req, _ := http.NewRequest("GET", "/", nil)
+	c := client{identity: "anonymous"}
+	if err := es.authorize(req, &c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.identity != "alice" {
+		t.Errorf("expected:\nalice\ngot:\n%s\n", c.identity)
+	}
+	principal := c.view().Principal()
+	principal.Attributes["role"] = "guest"
+	if c.principal.Attributes["role"] != "admin" {
+		t.Errorf("expected principal attributes to be copied")
+	}
+}
+
+func TestEventsourceAuthorizeErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{Unauthorized("missing token"), http.StatusUnauthorized},
+		{Forbidden("not allowed"), http.StatusForbidden},
+		{errors.New("denied"), http.StatusForbidden},
+	}
+	for _, test := range tests {
+		es := Eventsource{Authorizer: authorizerFunc(func(*http.Request, []string) (Principal, error) {
+			return Principal{}, test.err
+		})}
+		req, _ := http.NewRequest("GET", "/", nil)
+		err := es.authorize(req, &client{})
+		checkStatus(t, err, test.code)
+	}
+}
+
+func TestEventsourceServeHTTPUnauthorized(t *testing.T) {
+	es := &Eventsource{
+		Metrics:           NoopMetrics{},
+		ChannelSubscriber: QueryStringChannels{Name: "channels"},
+		Authorizer: authorizerFunc(func(req *http.Request, channels []string) (Principal, error) {
+			if len(channels) != 1 || channels[0] != "a" {
+				t.Errorf("expected:\n[a]\ngot:\n%q\n", channels)
+			}
+			return Principal{}, Unauthorized("missing token")
+		}),
+	}
+	es.Start()
+	defer es.Stop()
+	server := httptest.NewServer(es)
+	defer server.Close()
+	res, err := http.Get(server.URL + "?channels=a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected:\n%d\ngot:\n%d\n", http.StatusUnauthorized, res.StatusCode)
+	}
+	body := make([]byte, 64)
+	n, _ := res.Body.Read(body)
+	if !strings.HasPrefix(string(body[:n]), "missing token") {
+		t.Errorf("expected:\nmissing token\ngot:\n%s\n", body[:n])
+	}
+}
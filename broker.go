@@ -0,0 +1,122 @@
+package eventsource
+
+import "sync"
+
+// A Broker carries events between the nodes of a cluster, so an event sent on
+// one node reaches the clients connected to every node. Each Eventsource
+// publishes the events it sends and subscribes to the events published by
+// the other nodes. This package has two built-in implementations:
+// MemoryBroker and TCPBroker, but you can implement your own.
+type Broker interface {
+	// Publish sends a message to the other nodes.
+	Publish(Message) error
+
+	// Subscribe registers the handler called for each message published by
+	// another node.
+	Subscribe(handler func(Message))
+}
+
+// A Message is an event as it travels between nodes. Only events that can be
+// recorded, see Recordable, and retry events travel as messages: the
+// text/stream data is sent as it is, along with the event id and channels.
+// Events sent with SendTo or SendToUser keep their target client or user.
+type Message struct {
+	// ID is unique to each message, used by brokers to discard duplicates.
+	ID string `json:"id"`
+
+	EventID  string   `json:"event_id,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	Data     []byte   `json:"data"`
+	ClientID string   `json:"client_id,omitempty"`
+	Identity string   `json:"identity,omitempty"`
+
+	// Retry is set by retry events instead of the data, see RetryEvent.
+	Retry int `json:"retry,omitempty"`
+}
+
+// newMessage returns the message of an event, or false if the event can't
+// travel between nodes.
+func newMessage(e Event) (Message, bool) {
+	switch e := e.(type) {
+	case direct:
+		m, ok := newMessage(e.event)
+		m.ClientID = e.id
+		return m, ok
+	case personal:
+		m, ok := newMessage(e.event)
+		m.Identity = e.identity
+		return m, ok
+	case RetryEvent:
+		return Message{ID: newID(), Retry: e.Retry}, true
+	case Recordable:
+		r := e.Record()
+		return Message{ID: newID(), EventID: r.ID, Channels: r.Channels, Data: r.Data}, true
+	}
+	return Message{}, false
+}
+
+// event returns the event carried by the message.
+func (m Message) event() Event {
+	var e Event = Record{ID: m.EventID, Channels: m.Channels, Data: m.Data}
+	if m.Retry > 0 {
+		e = RetryEvent{Retry: m.Retry}
+	}
+	if m.ClientID != "" {
+		return direct{event: e, id: m.ClientID}
+	}
+	if m.Identity != "" {
+		return personal{event: e, identity: m.Identity}
+	}
+	return e
+}
+
+// MemoryBroker is a message bus between eventsources of the same process,
+// useful for tests. Each eventsource must have its own node, see Node.
+type MemoryBroker struct {
+	mu    sync.RWMutex
+	nodes []*memoryNode
+}
+
+// A memoryNode implements the Broker interface for one eventsource attached to
+// a MemoryBroker.
+type memoryNode struct {
+	bus     *MemoryBroker
+	handler func(Message)
+}
+
+// NewMemoryBroker returns an empty MemoryBroker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+// Node attaches a new node to the bus, returning the Broker of an eventsource.
+func (b *MemoryBroker) Node() Broker {
+	n := &memoryNode{bus: b}
+	b.mu.Lock()
+	b.nodes = append(b.nodes, n)
+	b.mu.Unlock()
+	return n
+}
+
+// Publish calls the handler of every other node, in order.
+func (n *memoryNode) Publish(m Message) error {
+	n.bus.mu.RLock()
+	var handlers []func(Message)
+	for _, other := range n.bus.nodes {
+		if other != n && other.handler != nil {
+			handlers = append(handlers, other.handler)
+		}
+	}
+	n.bus.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(m)
+	}
+	return nil
+}
+
+// Subscribe sets the handler of the node.
+func (n *memoryNode) Subscribe(handler func(Message)) {
+	n.bus.mu.Lock()
+	n.handler = handler
+	n.bus.mu.Unlock()
+}
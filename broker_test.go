@@ -0,0 +1,108 @@
+package eventsource
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessageEvent(t *testing.T) {
+	e := DefaultEvent{ID: "1", Message: message, Channels: []string{"a"}}
+	record := Record{ID: "1", Channels: []string{"a"}, Data: e.Bytes()}
+	tests := []struct {
+		event     Event
+		expecting Event
+	}{
+		{e, record},
+		{direct{event: e, id: "x"}, direct{event: record, id: "x"}},
+		{personal{event: e, identity: "42"}, personal{event: record, identity: "42"}},
+		{RetryEvent{Retry: 30000}, RetryEvent{Retry: 30000}},
+	}
+	for _, test := range tests {
+		m, ok := newMessage(test.event)
+		if !ok || m.ID == "" {
+			t.Fatalf("expected event to have a message\ngot:\n%v\n", m)
+		}
+		result := m.event()
+		if !reflect.DeepEqual(test.expecting, result) {
+			t.Errorf("expected:\n%v\ngot:\n%v\n", test.expecting, result)
+		}
+	}
+	if _, ok := newMessage(CloseEvent{}); ok {
+		t.Errorf("expected event that can't be recorded not to have a message")
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	bus := NewMemoryBroker()
+	n1, n2, n3 := bus.Node(), bus.Node(), bus.Node()
+	var received []string
+	n1.Subscribe(func(m Message) { received = append(received, "1:"+m.ID) })
+	n2.Subscribe(func(m Message) { received = append(received, "2:"+m.ID) })
+	n3.Subscribe(func(m Message) { received = append(received, "3:"+m.ID) })
+	n1.Publish(Message{ID: "a"})
+	expecting := []string{"2:a", "3:a"}
+	if !reflect.DeepEqual(expecting, received) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, received)
+	}
+}
+
+func TestEventsourceBroker(t *testing.T) {
+	bus := NewMemoryBroker()
+	es1 := Eventsource{Broker: bus.Node()}
+	es2 := Eventsource{Broker: bus.Node()}
+	events1 := make(chan Event, 2)
+	events2 := make(chan Event, 2)
+	es1.server = server{events: events1}
+	es2.server = server{events: events2}
+	es1.Broker.Subscribe(es1.receive)
+	es2.Broker.Subscribe(es2.receive)
+
+	e := DefaultEvent{ID: "1", Message: message}
+	es1.Send(e)
+	es1.Send(CloseEvent{})
+	if result := <-events1; !reflect.DeepEqual(e, result) {
+		t.Errorf("expected:\n%v\ngot:\n%v\n", e, result)
+	}
+	expecting := Record{ID: "1", Data: e.Bytes()}
+	select {
+	case result := <-events2:
+		if !reflect.DeepEqual(expecting, result) {
+			t.Errorf("expected:\n%v\ngot:\n%v\n", expecting, result)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("expected event to reach the other node")
+	}
+	if len(events2) > 0 {
+		t.Errorf("expected local only event not to reach the other node")
+	}
+}
+
+func TestEventsourceBrokerRetry(t *testing.T) {
+	bus := NewMemoryBroker()
+	es1 := Eventsource{Broker: bus.Node()}
+	es2 := Eventsource{Broker: bus.Node()}
+	events1 := make(chan Event, 2)
+	events2 := make(chan Event, 2)
+	es1.server = server{events: events1}
+	es2.server = server{events: events2}
+	es2.Broker.Subscribe(es2.receive)
+
+	es1.Send(RetryEvent{Retry: -1})
+	es1.Send(RetryEvent{Retry: 30000})
+	expecting := RetryEvent{Retry: 30000}
+	if result := <-events1; !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\ngot:\n%v\n", expecting, result)
+	}
+	select {
+	case result := <-events2:
+		if !reflect.DeepEqual(expecting, result) {
+			t.Errorf("expected:\n%v\ngot:\n%v\n", expecting, result)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("expected retry to reach the other node")
+	}
+	if len(events1) > 0 || len(events2) > 0 {
+		t.Errorf("expected retry that is not positive to be discarded")
+	}
+}
@@ -25,6 +25,8 @@ func (n NoChannels) ParseRequest(req *http.Request) []string {
 // QueryStringChannels implements the ChannelSubscriber interface by parsing
 // the request querystring and extracting channels separated by commas. Eg.:
 // /?channels=a,b,c
+// Channels can be wildcard subscriptions, see MatchChannel. Eg.:
+// /?channels=table.*,lobby.>
 type QueryStringChannels struct {
 	Name string
 }
@@ -38,3 +40,31 @@ func (n QueryStringChannels) ParseRequest(req *http.Request) []string {
 	}
 	return strings.Split(channels, ",")
 }
+
+// MatchChannel returns true if a channel matches a subscription pattern.
+// Channel names are hierarchical, with tokens separated by dots. In a pattern,
+// the token "*" matches exactly one token and the token ">", only allowed at
+// the end, matches one or more tokens. Eg.: "table.*" matches "table.1" but
+// not "table.1.chat", while "table.>" matches both.
+func MatchChannel(pattern, channel string) bool {
+	if pattern == channel {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*>") {
+		return false
+	}
+	for {
+		token, rest, more := strings.Cut(pattern, ".")
+		if token == ">" {
+			return !more && channel != ""
+		}
+		name, next, ok := strings.Cut(channel, ".")
+		if channel == "" || (token != "*" && token != name) {
+			return false
+		}
+		if !more || !ok {
+			return !more && !ok
+		}
+		pattern, channel = rest, next
+	}
+}
@@ -35,3 +35,47 @@ func TestQueryStringChannelsParseRequest(t *testing.T) {
 		t.Errorf("expected:\n%q\nto be equal to:\n%q\n", result, expecting)
 	}
 }
+
+func TestMatchChannel(t *testing.T) {
+	tests := []struct {
+		pattern string
+		channel string
+		match   bool
+	}{
+		{"a", "a", true},
+		{"a", "b", false},
+		{"table.1", "table.1", true},
+		{"table.*", "table.1", true},
+		{"table.*", "table", false},
+		{"table.*", "table.1.chat", false},
+		{"table.*.chat", "table.1.chat", true},
+		{"table.*.chat", "table.1.bets", false},
+		{"*", "table", true},
+		{"*", "table.1", false},
+		{"table.>", "table.1", true},
+		{"table.>", "table.1.chat", true},
+		{"table.>", "table", false},
+		{">", "table.1", true},
+		{"table.>.chat", "table.1.chat", false},
+		{"table.*", "lobby.1", false},
+		{"table.*", "", false},
+	}
+	for _, test := range tests {
+		result := MatchChannel(test.pattern, test.channel)
+		if test.match != result {
+			t.Errorf("expected %q matching %q to be:\n%t\ngot:\n%t\n",
+				test.pattern, test.channel, test.match, result)
+		}
+	}
+}
+
+func TestQueryStringChannelsParseRequestWildcards(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/?channels=table.*,lobby.>", nil)
+	sub := QueryStringChannels{Name: "channels"}
+	result := sub.ParseRequest(req)
+	expecting := []string{"table.*", "lobby.>"}
+
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\nto be equal to:\n%q\n", result, expecting)
+	}
+}
@@ -1,56 +1,320 @@
 package eventsource
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"net"
+	"net/http"
+	"net/url"
 	"time"
 )
 
+const (
+	// DefaultQueueSize is the number of events a client queue holds when no
+	// queue size is set.
+	DefaultQueueSize = 64
+
+	// DefaultWriteTimeout is how long a client has to write an event to its
+	// connection when no write timeout is set.
+	DefaultWriteTimeout = 10 * time.Millisecond
+)
+
+// An OverflowPolicy defines what happens when an event is sent to a client
+// whose queue is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest event in the queue to make room for the
+	// new one.
+	DropOldest OverflowPolicy = iota
+
+	// DropNewest discards the new event, keeping the queue as it is.
+	DropNewest
+
+	// DisconnectClient removes the client from the server and closes its
+	// connection. Browsers reconnect after the retry time and can recover
+	// missed events with the Last-Event-ID header.
+	DisconnectClient
+)
+
 // A client holds the actual connection to the browser, the channels names the
 // client has subscribed to, a queue to receive events and a done channel for
-// syncronization with pending events.
+// syncronization with pending events. The backlog holds events missed since
+// the last event id the browser received, written before any new event. When
+// the gone channel is closed, the browser is considered disconnected. When
+// the server removes the client, it sends on the quit channel the last data to
+// be written, if any, before the client closes its connection. The reason
+// tells the server why a client removed itself.
 type client struct {
-	events   chan payload
-	done     chan bool
-	channels []string
-	conn     net.Conn
+	id          string
+	identity    string
+	principal   Principal
+	remoteAddr  string
+	header      http.Header
+	query       url.Values
+	events      chan payload
+	done        chan bool
+	gone        <-chan struct{}
+	quit        chan []byte
+	channels    []string
+	conn        stream
+	timeout     time.Duration
+	lastEventID string
+	backlog     [][]byte
+	reason      DisconnectReason
+}
+
+// Client is a read-only view of a connected client, used by events to select
+// which clients must receive them.
+type Client struct {
+	id         string
+	identity   string
+	principal  Principal
+	channels   []string
+	remoteAddr string
+	header     http.Header
+	query      url.Values
+}
+
+// ID returns the unique id assigned to the client when it connected.
+func (c Client) ID() string {
+	return c.id
+}
+
+// Identity returns the user the client belongs to, as returned by the
+// IdentityResolver when the client connected.
+func (c Client) Identity() string {
+	return c.identity
+}
+
+// Principal returns the authenticated user behind the client, as returned by
+// the Authorizer when the client connected.
+func (c Client) Principal() Principal {
+	principal := Principal{ID: c.principal.ID, Expires: c.principal.Expires}
+	if c.principal.Attributes != nil {
+		principal.Attributes = make(map[string]string, len(c.principal.Attributes))
+		for k, v := range c.principal.Attributes {
+			principal.Attributes[k] = v
+		}
+	}
+	return principal
+}
+
+// Channels returns the channels the client has subscribed to.
+func (c Client) Channels() []string {
+	channels := make([]string, len(c.channels))
+	copy(channels, c.channels)
+	return channels
+}
+
+// RemoteAddr returns the network address of the browser, as found in the
+// initial http request.
+func (c Client) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// Header returns the headers of the initial http request.
+func (c Client) Header() http.Header {
+	return c.header.Clone()
+}
+
+// Query returns the querystring values of the initial http request.
+func (c Client) Query() url.Values {
+	query := make(url.Values, len(c.query))
+	for k, v := range c.query {
+		query[k] = append([]string(nil), v...)
+	}
+	return query
+}
+
+// view returns the read-only view of the client.
+func (c client) view() Client {
+	return Client{
+		id:         c.id,
+		identity:   c.identity,
+		principal:  c.principal,
+		channels:   c.channels,
+		remoteAddr: c.remoteAddr,
+		header:     c.header,
+		query:      c.query,
+	}
+}
+
+// newID returns a random hex encoded id.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // A payload contains the event data that must be written to the client
-// connection and a done channel to signalize the end of the writing process
+// connection and a done channel to signalize the end of the writing process.
+// Heartbeat payloads carry the server ping. The data of Renderer events is
+// rendered by the client when it writes the payload, see render.
 type payload struct {
-	data []byte
-	done chan time.Duration
+	data      []byte
+	render    func() []byte
+	done      chan time.Duration
+	heartbeat bool
+}
+
+// bytes returns the data written to the client, rendering it if needed.
+func (p payload) bytes() []byte {
+	if p.render != nil {
+		return p.render()
+	}
+	return p.data
+}
+
+// wait receives size durations from the payload done channel.
+func (p payload) wait(size int) []time.Duration {
+	durations := make([]time.Duration, 0, size)
+	for i := 0; i < size; i++ {
+		durations = append(durations, <-p.done)
+	}
+	return durations
+}
+
+// report sends a duration to the payload done channel, if it has one.
+func (p payload) report(d time.Duration) {
+	if p.done != nil {
+		p.done <- d
+	}
 }
 
 // The listen function receives incoming events on the events channel, writing
 // them to its underlining connection. If there is an error, the client send a
-// message to remove itself from the pool through the remove channel passed in
-// and notifies pending events by closing the done channel.
+// message to remove itself from the pool through the remove channel passed in,
+// as it does when the gone channel is closed or when its principal expires.
+// The done channel is closed when the client stops listening, notifying
+// pending events and the server shutdown.
 func (c *client) listen(remove chan<- client) {
+	defer close(c.done)
+	var expired <-chan time.Time
+	if !c.principal.Expires.IsZero() {
+		timer := time.NewTimer(time.Until(c.principal.Expires))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for _, data := range c.backlog {
+		if err := c.write(data); err != nil {
+			c.leave(remove, writeFailure(err, false))
+			return
+		}
+	}
 	for {
-		e, ok := <-c.events
+		var e payload
+		var ok bool
+		select {
+		case e, ok = <-c.events:
+		case <-c.gone:
+			c.leave(remove, ClientGone)
+			return
+		case <-expired:
+			c.write(CloseEvent{Reason: ExpiredError}.Bytes())
+			c.leave(remove, Expired)
+			return
+		case data := <-c.quit:
+			if data != nil {
+				c.write(data)
+			}
+			c.conn.Close()
+			return
+		}
 		if !ok {
 			c.conn.Close()
 			return
 		}
 
 		start := time.Now()
-		c.conn.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
-		_, err := c.conn.Write(e.data)
-
-		if e.done != nil {
-			if err == nil {
-				e.done <- time.Since(start)
-			} else {
-				e.done <- 0
-			}
+		err := c.write(e.bytes())
+
+		if err == nil {
+			e.report(time.Since(start))
+		} else {
+			e.report(0)
 		}
 
 		if err != nil {
-			remove <- *c
-			c.conn.Close()
-			close(c.done)
-			break
+			c.leave(remove, writeFailure(err, e.heartbeat))
+			return
+		}
+	}
+}
+
+// leave asks the server to remove the client for the reason passed in and
+// closes its connection.
+func (c *client) leave(remove chan<- client, reason DisconnectReason) {
+	removed := *c
+	removed.reason = reason
+	remove <- removed
+	c.conn.Close()
+}
+
+// writeFailure returns the disconnect reason of a write error.
+func writeFailure(err error, heartbeat bool) DisconnectReason {
+	if heartbeat {
+		return HeartbeatFailure
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return WriteTimeout
+	}
+	return WriteError
+}
+
+// write writes data to the client connection, failing if it takes longer than
+// the client write timeout.
+func (c *client) write(data []byte) error {
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// enqueue puts a payload on the client queue without blocking. If the queue is
+// full, the overflow policy is applied and payloads that are discarded report
+// a 0 duration. It returns false if the client must be disconnected.
+func (c client) enqueue(p payload, policy OverflowPolicy) bool {
+	select {
+	case c.events <- p:
+		return true
+	default:
+	}
+	switch policy {
+	case DropOldest:
+		select {
+		case old := <-c.events:
+			old.report(0)
+		default:
+		}
+		select {
+		case c.events <- p:
+			return true
+		default:
+		}
+	case DisconnectClient:
+		p.report(0)
+		return false
+	}
+	p.report(0)
+	return true
+}
+
+// drain empties the client queue, reporting a 0 duration for each payload that
+// won't be written.
+func (c client) drain() {
+	for {
+		select {
+		case p, ok := <-c.events:
+			if !ok {
+				return
+			}
+			p.report(0)
+		default:
+			return
 		}
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"net"
+	"net/http"
 	"reflect"
 	"testing"
 	"time"
@@ -61,6 +62,7 @@ func TestClientListenConnError(t *testing.T) {
 	}
 	go func() {
 		removed := <-remove
+		c.reason = WriteError
 		if !reflect.DeepEqual(c, removed) {
 			t.Errorf("expected:\n%v\ngot:\n%v\n", c, removed)
 		}
@@ -70,3 +72,160 @@ func TestClientListenConnError(t *testing.T) {
 		t.Errorf("expected channel to be closed")
 	}
 }
+
+func TestClientListenExpired(t *testing.T) {
+	remove := make(chan client, 1)
+	read, write := net.Pipe()
+	c := client{
+		done:      make(chan bool),
+		conn:      write,
+		events:    make(chan payload),
+		principal: Principal{Expires: time.Now().Add(10 * time.Millisecond)},
+	}
+	go c.listen(remove)
+	checkRead(t, read, CloseEvent{Reason: ExpiredError}.Bytes(), nil)
+	checkRead(t, read, nil, io.EOF)
+	select {
+	case <-remove:
+	case <-time.After(1 * time.Second):
+		t.Errorf("expected expired client to be removed")
+	}
+}
+
+func TestClientListenBacklog(t *testing.T) {
+	remove := make(chan client)
+	read, write := net.Pipe()
+	c := client{
+		done:    make(chan bool),
+		conn:    write,
+		events:  make(chan payload),
+		backlog: [][]byte{[]byte("1"), []byte("2")},
+	}
+	go c.listen(remove)
+	go func() {
+		c.events <- payload{data: []byte("3"), done: make(chan time.Duration, 1)}
+	}()
+	checkRead(t, read, []byte("1"), nil)
+	checkRead(t, read, []byte("2"), nil)
+	checkRead(t, read, []byte("3"), nil)
+}
+
+func TestClientEnqueueDropOldest(t *testing.T) {
+	c := client{events: make(chan payload, 1)}
+	old := payload{data: []byte("old"), done: make(chan time.Duration, 1)}
+	p := payload{data: []byte("new")}
+	c.enqueue(old, DropOldest)
+	if ok := c.enqueue(p, DropOldest); !ok {
+		t.Errorf("expected client to be kept")
+	}
+	if d := <-old.done; d != 0 {
+		t.Errorf("expected:\n0\ngot:\n%s\n", d)
+	}
+	result := <-c.events
+	if !bytes.Equal(p.data, result.data) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", p.data, result.data)
+	}
+}
+
+func TestClientEnqueueDropNewest(t *testing.T) {
+	c := client{events: make(chan payload, 1)}
+	old := payload{data: []byte("old")}
+	p := payload{data: []byte("new"), done: make(chan time.Duration, 1)}
+	c.enqueue(old, DropNewest)
+	if ok := c.enqueue(p, DropNewest); !ok {
+		t.Errorf("expected client to be kept")
+	}
+	if d := <-p.done; d != 0 {
+		t.Errorf("expected:\n0\ngot:\n%s\n", d)
+	}
+	result := <-c.events
+	if !bytes.Equal(old.data, result.data) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", old.data, result.data)
+	}
+}
+
+func TestClientEnqueueDisconnect(t *testing.T) {
+	c := client{events: make(chan payload, 1)}
+	c.enqueue(payload{}, DisconnectClient)
+	if ok := c.enqueue(payload{}, DisconnectClient); ok {
+		t.Errorf("expected client to be disconnected")
+	}
+}
+
+func TestClientListenQuit(t *testing.T) {
+	read, write := net.Pipe()
+	c := client{
+		done:   make(chan bool),
+		quit:   make(chan []byte, 1),
+		conn:   write,
+		events: make(chan payload),
+	}
+	go c.listen(make(chan client))
+	c.quit <- nil
+	checkRead(t, read, nil, io.EOF)
+}
+
+func TestClientWriteTimeout(t *testing.T) {
+	_, write := net.Pipe()
+	c := client{conn: write, timeout: 1 * time.Millisecond}
+	err := c.write([]byte("test"))
+	if err == nil {
+		t.Errorf("expected write to time out")
+	}
+}
+
+func TestClientDrain(t *testing.T) {
+	c := client{events: make(chan payload, 2)}
+	done := make(chan time.Duration, 2)
+	c.events <- payload{done: done}
+	c.events <- payload{done: done}
+	c.drain()
+
+	expecting := []time.Duration{0, 0}
+	result := payload{done: done}.wait(2)
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+	if len(c.events) > 0 {
+		t.Errorf("expected queue to be empty")
+	}
+}
+
+func TestClientView(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/?channels=a", nil)
+	req.Header.Set("X-User", "1")
+	c := client{
+		id:         "id",
+		channels:   []string{"a"},
+		remoteAddr: "127.0.0.1:80",
+		header:     req.Header,
+		query:      req.URL.Query(),
+	}
+	v := c.view()
+	if v.ID() != "id" {
+		t.Errorf("expected:\nid\ngot:\n%s\n", v.ID())
+	}
+	if v.RemoteAddr() != "127.0.0.1:80" {
+		t.Errorf("expected:\n127.0.0.1:80\ngot:\n%s\n", v.RemoteAddr())
+	}
+	if v.Header().Get("X-User") != "1" {
+		t.Errorf("expected:\n1\ngot:\n%s\n", v.Header().Get("X-User"))
+	}
+	if v.Query().Get("channels") != "a" {
+		t.Errorf("expected:\na\ngot:\n%s\n", v.Query().Get("channels"))
+	}
+
+	channels := v.Channels()
+	channels[0] = "b"
+	v.Header().Set("X-User", "2")
+	v.Query().Set("channels", "b")
+	if !reflect.DeepEqual([]string{"a"}, v.Channels()) {
+		t.Errorf("expected channels to be read-only\ngot:\n%v\n", v.Channels())
+	}
+	if v.Header().Get("X-User") != "1" {
+		t.Errorf("expected header to be read-only\ngot:\n%s\n", v.Header().Get("X-User"))
+	}
+	if v.Query().Get("channels") != "a" {
+		t.Errorf("expected query to be read-only\ngot:\n%s\n", v.Query().Get("channels"))
+	}
+}
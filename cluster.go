@@ -0,0 +1,192 @@
+package eventsource
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultReplicas is the number of points each node has on the hash ring when
+// no number of replicas is set.
+const DefaultReplicas = 128
+
+// MixedOwnersError is displayed when a client subscribes to channels owned by
+// different nodes.
+var MixedOwnersError = "channels belong to different nodes"
+
+// UnroutableChannelError is displayed when a client subscribes to a wildcard
+// subscription whose first token is a wildcard, which no single node owns.
+var UnroutableChannelError = "channel can't be routed to a node"
+
+// UnknownNodeAddressError is displayed when a client must be redirected to a
+// node whose HTTP address is unknown, see Cluster.Addrs.
+var UnknownNodeAddressError = "node address is unknown"
+
+// A Forwarder sends messages to one node of a cluster. TCPBroker implements
+// the Forwarder interface, using the addresses of its peers as node names.
+type Forwarder interface {
+	// Forward sends a message to the node.
+	Forward(node string, m Message) error
+
+	// Subscribe registers the handler called for each message forwarded to
+	// this node.
+	Subscribe(handler func(Message))
+}
+
+// A Cluster assigns each channel to one node with consistent hashing, so
+// each node only receives the events of the channels it owns. Channels are
+// assigned by their first token, so "table.1", "table.2" and "table.*" belong
+// to the same node. Browsers connecting to a node that doesn't own their
+// channels are redirected to the owner, and events sent on any node are
+// forwarded to the owner of their channels. Events without channels and
+// events sent to a client or a user are forwarded to every node. Clients can
+// only be subscribed at runtime to channels owned by their node, see
+// Eventsource.Subscribe.
+type Cluster struct {
+	// Self is the name of this node, one of Nodes.
+	Self string
+
+	// Nodes are the names of every node in the cluster, in any order but the
+	// same in every node.
+	Nodes []string
+
+	// Replicas is the number of points of each node on the hash ring. It
+	// defaults to DefaultReplicas.
+	Replicas int
+
+	// Forwarder sends events to the nodes that own them.
+	Forwarder Forwarder
+
+	// Addrs are the HTTP addresses, host and port, browsers are redirected
+	// to, by node name. Nodes without an address are reached at their name,
+	// unless the Forwarder is a TCPBroker: node names are then the addresses
+	// of its peers, which don't serve HTTP, so every node needs an address.
+	Addrs map[string]string
+
+	// Redirect returns the URL a request must be redirected to, to reach the
+	// node. It defaults to the request URL with the node address as host, see
+	// Addrs.
+	Redirect func(node string, req *http.Request) string
+
+	once   sync.Once
+	points []uint64
+	owners map[uint64]string
+}
+
+// build creates the hash ring.
+func (cl *Cluster) build() {
+	replicas := cl.Replicas
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	cl.owners = make(map[uint64]string)
+	for _, node := range cl.Nodes {
+		for i := 0; i < replicas; i++ {
+			point := hashKey(node + "#" + strconv.Itoa(i))
+			cl.owners[point] = node
+			cl.points = append(cl.points, point)
+		}
+	}
+	sort.Slice(cl.points, func(i, j int) bool { return cl.points[i] < cl.points[j] })
+}
+
+// Owner returns the node owning a channel, or false if the channel first token
+// is a wildcard.
+func (cl *Cluster) Owner(channel string) (string, bool) {
+	cl.once.Do(cl.build)
+	key, _, _ := strings.Cut(channel, ".")
+	if key == "*" || key == ">" || len(cl.points) == 0 {
+		return "", false
+	}
+	point := hashKey(key)
+	i := sort.Search(len(cl.points), func(i int) bool { return cl.points[i] >= point })
+	if i == len(cl.points) {
+		i = 0
+	}
+	return cl.owners[cl.points[i]], true
+}
+
+// home returns the node a client subscribed to the channels must connect to.
+// Clients without channels can connect to any node.
+func (cl *Cluster) home(channels []string) (string, error) {
+	home := cl.Self
+	for i, name := range channels {
+		owner, ok := cl.Owner(name)
+		if !ok {
+			return "", StatusError{http.StatusBadRequest, UnroutableChannelError}
+		}
+		if i > 0 && owner != home {
+			return "", StatusError{http.StatusBadRequest, MixedOwnersError}
+		}
+		home = owner
+	}
+	return home, nil
+}
+
+// owned returns the channels owned by this node. Other channels are refused:
+// their events are only sent to their owner, so local clients would never
+// receive them.
+func (cl *Cluster) owned(channels []string) []string {
+	var owned []string
+	for _, name := range channels {
+		if owner, ok := cl.Owner(name); !ok || owner != cl.Self {
+			log.Printf("Subscription to %s refused - not owned by this node\n", name)
+			continue
+		}
+		owned = append(owned, name)
+	}
+	return owned
+}
+
+// destinations returns the nodes an event must be sent to.
+func (cl *Cluster) destinations(e Event) []string {
+	channels, ok := route(e)
+	if !ok || len(channels) == 0 {
+		return cl.Nodes
+	}
+	var nodes []string
+	for _, name := range channels {
+		owner, ok := cl.Owner(name)
+		if !ok {
+			return cl.Nodes
+		}
+		if !contains(nodes, owner) {
+			nodes = append(nodes, owner)
+		}
+	}
+	return nodes
+}
+
+// redirect returns the URL of the request on the node.
+func (cl *Cluster) redirect(node string, req *http.Request) (string, error) {
+	if cl.Redirect != nil {
+		return cl.Redirect(node, req), nil
+	}
+	host, ok := cl.Addrs[node]
+	if !ok {
+		if _, tcp := cl.Forwarder.(*TCPBroker); tcp {
+			return "", StatusError{http.StatusServiceUnavailable, UnknownNodeAddressError}
+		}
+		host = node
+	}
+	u := *req.URL
+	u.Host = host
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	return u.String(), nil
+}
+
+// hashKey returns the position of a key on the hash ring.
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,182 @@
+package eventsource
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type fakeForwarder struct {
+	forwarded map[string][]Message
+	handler   func(Message)
+}
+
+func (f *fakeForwarder) Forward(node string, m Message) error {
+	if f.forwarded == nil {
+		f.forwarded = make(map[string][]Message)
+	}
+	f.forwarded[node] = append(f.forwarded[node], m)
+	return nil
+}
+
+func (f *fakeForwarder) Subscribe(handler func(Message)) {
+	f.handler = handler
+}
+
+// channelOwnedBy returns a channel owned by the node.
+func channelOwnedBy(t *testing.T, cl *Cluster, node string) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		channel := fmt.Sprintf("table%d", i)
+		if owner, _ := cl.Owner(channel); owner == node {
+			return channel
+		}
+	}
+	t.Fatalf("expected node %s to own a channel", node)
+	return ""
+}
+
+func TestClusterOwner(t *testing.T) {
+	cl := &Cluster{Nodes: []string{"a", "b", "c"}}
+	owned := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		owner, ok := cl.Owner(fmt.Sprintf("table%d.chat", i))
+		if !ok {
+			t.Fatalf("expected channel to have an owner")
+		}
+		owned[owner]++
+	}
+	for _, node := range cl.Nodes {
+		if owned[node] < 50 {
+			t.Errorf("expected channels to be spread between nodes\ngot:\n%v\n", owned)
+		}
+	}
+
+	owner, _ := cl.Owner("table.1")
+	for _, channel := range []string{"table", "table.2", "table.*", "table.>"} {
+		if result, _ := cl.Owner(channel); result != owner {
+			t.Errorf("expected %s to be owned by %s\ngot:\n%s\n", channel, owner, result)
+		}
+	}
+	if _, ok := cl.Owner("*.chat"); ok {
+		t.Errorf("expected channel starting with a wildcard not to have an owner")
+	}
+
+	other := &Cluster{Nodes: []string{"c", "a", "b"}}
+	if result, _ := other.Owner("table.1"); result != owner {
+		t.Errorf("expected owners not to depend on the nodes order")
+	}
+}
+
+func TestClusterHome(t *testing.T) {
+	cl := &Cluster{Self: "a", Nodes: []string{"a", "b"}}
+	ownedByA := channelOwnedBy(t, cl, "a")
+	ownedByB := channelOwnedBy(t, cl, "b")
+	if home, err := cl.home(nil); err != nil || home != "a" {
+		t.Errorf("expected client without channels to stay\ngot:\n%s %v\n", home, err)
+	}
+	if home, _ := cl.home([]string{ownedByB, ownedByB + ".chat"}); home != "b" {
+		t.Errorf("expected:\nb\ngot:\n%s\n", home)
+	}
+	_, err := cl.home([]string{ownedByA, ownedByB})
+	checkStatus(t, err, http.StatusBadRequest)
+	_, err = cl.home([]string{">"})
+	checkStatus(t, err, http.StatusBadRequest)
+}
+
+func TestEventsourceClusterSubscribe(t *testing.T) {
+	cl := &Cluster{Self: "a", Nodes: []string{"a", "b"}}
+	ownedByA := channelOwnedBy(t, cl, "a")
+	ownedByB := channelOwnedBy(t, cl, "b")
+	es := &Eventsource{Cluster: cl}
+	subs := make(chan subscription, 1)
+	es.server = server{subs: subs}
+
+	es.Subscribe(ByID("1"), ownedByA, ownedByB, "*.chat", ownedByA+".chat")
+	expecting := []string{ownedByA, ownedByA + ".chat"}
+	if sub := <-subs; !reflect.DeepEqual(expecting, sub.channels) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, sub.channels)
+	}
+
+	es.Unsubscribe(ByID("1"), ownedByB)
+	if sub := <-subs; !reflect.DeepEqual([]string{ownedByB}, sub.channels) {
+		t.Errorf("expected unsubscribing from any channel to be allowed\ngot:\n%q\n", sub.channels)
+	}
+}
+
+func TestEventsourceClusterRedirect(t *testing.T) {
+	cl := &Cluster{Self: "a", Nodes: []string{"a", "b:8080"}}
+	es := &Eventsource{
+		Metrics:           NoopMetrics{},
+		ChannelSubscriber: QueryStringChannels{Name: "channels"},
+		Cluster:           cl,
+		Streaming:         true,
+	}
+	es.Start()
+	defer es.Stop()
+	channel := channelOwnedBy(t, cl, "b:8080")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/events?channels="+channel, nil)
+	es.ServeHTTP(w, r)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected:\n%d\ngot:\n%d\n", http.StatusTemporaryRedirect, w.Code)
+	}
+	expecting := "http://b:8080/events?channels=" + channel
+	if location := w.Header().Get("Location"); location != expecting {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, location)
+	}
+}
+
+func TestEventsourceClusterSend(t *testing.T) {
+	forwarder := &fakeForwarder{}
+	cl := &Cluster{Self: "a", Nodes: []string{"a", "b", "c"}, Forwarder: forwarder}
+	es := Eventsource{Cluster: cl}
+	events := make(chan Event, 3)
+	es.server = server{events: events}
+
+	ownedByA := channelOwnedBy(t, cl, "a")
+	ownedByB := channelOwnedBy(t, cl, "b")
+	es.Send(DefaultEvent{Message: message, Channels: []string{ownedByA}})
+	es.Send(DefaultEvent{Message: message, Channels: []string{ownedByB}})
+	es.Send(DefaultEvent{Message: message})
+
+	if len(events) != 2 {
+		t.Errorf("expected:\n2 local events\ngot:\n%d\n", len(events))
+	}
+	if len(forwarder.forwarded["b"]) != 2 || len(forwarder.forwarded["c"]) != 1 {
+		t.Errorf("expected events to be forwarded to their owners\ngot:\n%v\n", forwarder.forwarded)
+	}
+	if !reflect.DeepEqual([]string{ownedByB}, forwarder.forwarded["b"][0].Channels) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{ownedByB}, forwarder.forwarded["b"][0].Channels)
+	}
+}
+
+func TestClusterDestinationsTypedEvent(t *testing.T) {
+	cl := &Cluster{Self: "a", Nodes: []string{"a", "b", "c"}}
+	channel := channelOwnedBy(t, cl, "b")
+	typed, _ := NewTypedEvent("score", 1, channel)
+	for _, e := range []Event{typed, CommentEvent{Channels: []string{channel}}} {
+		if result := cl.destinations(e); !reflect.DeepEqual([]string{"b"}, result) {
+			t.Errorf("expected %T to be sent to:\n%q\ngot:\n%q\n", e, []string{"b"}, result)
+		}
+	}
+}
+
+func TestClusterRedirectAddrs(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/events?channels=table", nil)
+	cl := &Cluster{Self: "a", Nodes: []string{"a", "b"}, Addrs: map[string]string{"b": "b.example.com:8080"}}
+	if location, _ := cl.redirect("b", r); location != "http://b.example.com:8080/events?channels=table" {
+		t.Errorf("expected redirect to the node address\ngot:\n%s\n", location)
+	}
+
+	cl = &Cluster{Self: "10.0.0.1:7000", Nodes: []string{"10.0.0.1:7000", "10.0.0.2:7000"}, Forwarder: &TCPBroker{}}
+	_, err := cl.redirect("10.0.0.2:7000", r)
+	checkStatus(t, err, http.StatusServiceUnavailable)
+
+	cl.Addrs = map[string]string{"10.0.0.2:7000": "10.0.0.2:8080"}
+	if location, _ := cl.redirect("10.0.0.2:7000", r); location != "http://10.0.0.2:8080/events?channels=table" {
+		t.Errorf("expected redirect to the node HTTP address\ngot:\n%s\n", location)
+	}
+}
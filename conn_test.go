@@ -59,9 +59,15 @@ func (w *connClosed) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return w.conn, nil, nil
 }
 
+// tcpListener is kept referenced so the garbage collector doesn't close it
+// while stub clients are still dialing.
+var tcpListener net.Listener
+
 func stubTCPClient() client {
-	net.Listen("tcp4", "127.0.0.1:4000")
-	conn, _ := net.Dial("tcp4", "127.0.0.1:4000")
-	c := client{events: make(chan payload), conn: conn, done: make(chan bool)}
+	if tcpListener == nil {
+		tcpListener, _ = net.Listen("tcp4", "127.0.0.1:0")
+	}
+	conn, _ := net.Dial("tcp4", tcpListener.Addr().String())
+	c := client{events: make(chan payload, 1), conn: conn, done: make(chan bool)}
 	return c
 }
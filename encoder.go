@@ -0,0 +1,90 @@
+package eventsource
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Fields are the fields of an event in the text/event-stream format. Bytes
+// encodes them following the WHATWG specification, so any data can be sent
+// without corrupting the stream.
+type Fields struct {
+	// ID sets the browser last event id. Line breaks and NULL characters,
+	// which would make browsers ignore or misread the field, are removed.
+	ID string
+
+	// Event is the event name. Line breaks are removed.
+	Event string
+
+	// Data is the event data. Line breaks, CRLF, CR or LF, are normalized and
+	// each line is written as its own data field, so browsers receive the data
+	// with LF line breaks.
+	Data []byte
+
+	// Retry, when greater than zero, sets the time in milliseconds the browser
+	// waits before reconnecting.
+	Retry int
+}
+
+// Bytes returns the text/stream message with the retry, id, event and data
+// fields, in this order, followed by the blank line that dispatches the
+// event.
+func (f Fields) Bytes() []byte {
+	var buf bytes.Buffer
+	if f.Retry > 0 {
+		buf.WriteString("retry: ")
+		buf.WriteString(strconv.Itoa(f.Retry))
+		buf.WriteString("\n")
+	}
+	if id := sanitize(f.ID, "\r\n\x00"); id != "" {
+		buf.WriteString("id: ")
+		buf.WriteString(id)
+		buf.WriteString("\n")
+	}
+	if name := sanitize(f.Event, "\r\n"); name != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(name)
+		buf.WriteString("\n")
+	}
+	writeData(&buf, f.Data)
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// writeData writes a data field for each line of data. Empty data is written
+// as a single empty data field.
+func writeData(buf *bytes.Buffer, data []byte) {
+	for {
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			buf.WriteString("data: ")
+			buf.Write(data)
+			buf.WriteString("\n")
+			return
+		}
+		buf.WriteString("data: ")
+		buf.Write(data[:i])
+		buf.WriteString("\n")
+		if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			i++
+		}
+		data = data[i+1:]
+	}
+}
+
+// lineBreaks normalizes CRLF and CR line breaks to LF.
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// sanitize removes the characters in cutset from a field value.
+func sanitize(value, cutset string) string {
+	if !strings.ContainsAny(value, cutset) {
+		return value
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(cutset, r) {
+			return -1
+		}
+		return r
+	}, value)
+}
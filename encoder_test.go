@@ -0,0 +1,54 @@
+package eventsource
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFieldsBytes(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    Fields
+		expecting string
+	}{
+		{"empty", Fields{}, "data: \n\n"},
+		{"data", Fields{Data: []byte("hello")}, "data: hello\n\n"},
+		{"leading space", Fields{Data: []byte(" hello")}, "data:  hello\n\n"},
+		{"colon", Fields{Data: []byte(":hello")}, "data: :hello\n\n"},
+		{"lf", Fields{Data: []byte("a\nb")}, "data: a\ndata: b\n\n"},
+		{"cr", Fields{Data: []byte("a\rb")}, "data: a\ndata: b\n\n"},
+		{"crlf", Fields{Data: []byte("a\r\nb")}, "data: a\ndata: b\n\n"},
+		{"lf cr", Fields{Data: []byte("a\n\rb")}, "data: a\ndata: \ndata: b\n\n"},
+		{"blank lines", Fields{Data: []byte("a\n\nb")}, "data: a\ndata: \ndata: b\n\n"},
+		{"trailing lf", Fields{Data: []byte("a\n")}, "data: a\ndata: \n\n"},
+		{"trailing crlf", Fields{Data: []byte("a\r\n")}, "data: a\ndata: \n\n"},
+		{"only lf", Fields{Data: []byte("\n")}, "data: \ndata: \n\n"},
+		{"id", Fields{ID: "1", Data: []byte("a")}, "id: 1\ndata: a\n\n"},
+		{"id with lf", Fields{ID: "1\nevent: x", Data: []byte("a")}, "id: 1event: x\ndata: a\n\n"},
+		{"id with cr", Fields{ID: "1\r2", Data: []byte("a")}, "id: 12\ndata: a\n\n"},
+		{"id with null", Fields{ID: "1\x002", Data: []byte("a")}, "id: 12\ndata: a\n\n"},
+		{"id only line breaks", Fields{ID: "\r\n", Data: []byte("a")}, "data: a\n\n"},
+		{"event", Fields{Event: "score", Data: []byte("a")}, "event: score\ndata: a\n\n"},
+		{"event with lf", Fields{Event: "score\ndata: injected", Data: []byte("a")}, "event: scoredata: injected\ndata: a\n\n"},
+		{"event with crlf", Fields{Event: "a\r\nb", Data: []byte("a")}, "event: ab\ndata: a\n\n"},
+		{"retry", Fields{Retry: 3000, Data: []byte("a")}, "retry: 3000\ndata: a\n\n"},
+		{"negative retry", Fields{Retry: -1, Data: []byte("a")}, "data: a\n\n"},
+		{"all fields", Fields{ID: "7", Event: "e", Data: []byte("x\ny"), Retry: 10}, "retry: 10\nid: 7\nevent: e\ndata: x\ndata: y\n\n"},
+		{"unicode", Fields{Event: "é", Data: []byte("ü ö")}, "event: é\ndata: ü ö\n\n"},
+	}
+	for _, test := range tests {
+		result := test.fields.Bytes()
+		if !bytes.Equal([]byte(test.expecting), result) {
+			t.Errorf("%s: expected:\n%q\ngot:\n%q\n", test.name, test.expecting, result)
+		}
+	}
+}
+
+func TestDefaultEventBytesMultiline(t *testing.T) {
+	e := DefaultEvent{ID: "2", Name: "chat\n", Message: []byte("line 1\r\nline 2"), Retry: 500}
+	expecting := []byte("retry: 500\nid: 2\nevent: chat\ndata: line 1\ndata: line 2\n\n")
+	result := e.Bytes()
+	if !bytes.Equal(expecting, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, result)
+	}
+}
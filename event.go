@@ -5,6 +5,7 @@ import (
 	"compress/zlib"
 	"encoding/base64"
 	"strconv"
+	"strings"
 )
 
 // Event is an interface that defines what the event payload is and to which
@@ -13,63 +14,59 @@ type Event interface {
 	// Bytes returns the data to be written on the clients connection
 	Bytes() []byte
 
-	// Clients receives a list of clients and return a filtered list.
-	Clients([]client) []client
+	// Match returns true if the event must be sent to the client.
+	Match(Client) bool
 }
 
-// DefaultEvent implements the Event interface
+// DefaultEvent implements the Event interface. Retry, when set, changes the
+// time in milliseconds browsers wait before reconnecting.
 type DefaultEvent struct {
-	ID       int
+	ID       string
 	Name     string
 	Message  []byte
 	Channels []string
 	Compress bool
+	Retry    int
 }
 
-// Bytes returns the text/stream message to be sent to the client.
-// If the event has name, it is added first, then the data. Optionally, the data
-// can be compressed using zlib.
+// Bytes returns the text/stream message to be sent to the client, see Fields.
+// If the event has an id or a name, they are added first, then the data, split
+// in one data field per line. Optionally, the data can be compressed using
+// zlib.
 func (e DefaultEvent) Bytes() []byte {
-	var buf bytes.Buffer
-	if e.ID > 0 {
-		buf.WriteString("id: ")
-		buf.WriteString(strconv.Itoa(e.ID))
-		buf.WriteString("\n")
-	}
-	if e.Name != "" {
-		buf.WriteString("event: ")
-		buf.WriteString(e.Name)
-		buf.WriteString("\n")
-	}
-	buf.WriteString("data: ")
+	f := Fields{ID: e.ID, Event: e.Name, Data: e.Message, Retry: e.Retry}
 	if e.Compress {
-		buf.WriteString(e.deflate())
-	} else {
-		buf.Write(e.Message)
+		f.Data = []byte(e.deflate())
 	}
-	buf.WriteString("\n\n")
-	return buf.Bytes()
+	return f.Bytes()
 }
 
-// Clients selects clients that have at least one channel in
-// common with the event or all clients if the event has no channel.
-func (e DefaultEvent) Clients(clients []client) []client {
-	if len(e.Channels) == 0 {
-		return clients
-	}
-	var subscribed []client
-	for _, client := range clients {
-	channels:
-		for _, cChans := range client.channels {
-			for _, eChans := range e.Channels {
-				if cChans == eChans {
-					subscribed = append(subscribed, client)
-					break channels
-				}
-			}
-		}
-	}
-	return subscribed
+// Match selects clients that have at least one channel in common with the
+// event or all clients if the event has no channel.
+func (e DefaultEvent) Match(c Client) bool {
+	return len(e.Channels) == 0 || subscribes(c.channels, e.Channels)
+}
+
+// channels returns the event channels, see routed.
+func (e DefaultEvent) channels() []string {
+	return e.Channels
+}
+
+// Record returns the event as a Record to be kept in the history. Events
+// without an ID return a record with an empty ID and are never replayed.
+func (e DefaultEvent) Record() Record {
+	return Record{ID: e.ID, Channels: e.Channels, Data: e.Bytes()}
+}
+
+// EventID returns the event id.
+func (e DefaultEvent) EventID() string {
+	return e.ID
+}
+
+// WithID returns a copy of the event with the id passed in.
+func (e DefaultEvent) WithID(id string) Event {
+	e.ID = id
+	return e
 }
 
 // deflate compress the event message using zlib default compression and
@@ -82,12 +79,144 @@ func (e DefaultEvent) deflate() string {
 	return base64.StdEncoding.EncodeToString(buf.Bytes())
 }
 
+// A Recordable event can be stored in the history and replayed to clients
+// reconnecting with a Last-Event-ID header.
+type Recordable interface {
+	Record() Record
+}
+
+// A Record is a snapshot of an event: its ID, the channels it was sent to and
+// the text/stream data written to clients. Record implements the Event
+// interface, so replayed events follow the same channel rules as live ones.
+type Record struct {
+	ID       string
+	Channels []string
+	Data     []byte
+}
+
+// Bytes returns the recorded text/stream data.
+func (r Record) Bytes() []byte {
+	return r.Data
+}
+
+// Match selects clients that have at least one channel in common with the
+// record or all clients if the record has no channel.
+func (r Record) Match(c Client) bool {
+	return DefaultEvent{Channels: r.Channels}.Match(c)
+}
+
+// channels returns the record channels, see routed.
+func (r Record) channels() []string {
+	return r.Channels
+}
+
+// Record returns the record itself, so records received from other nodes are
+// kept in the history too.
+func (r Record) Record() Record {
+	return r
+}
+
+// subscribes returns true when a client subscribed to the channels passed in
+// should receive an event sent to eventChannels. Client channels can be
+// wildcard subscriptions, see MatchChannel.
+func subscribes(channels, eventChannels []string) bool {
+	for _, c := range channels {
+		for _, e := range eventChannels {
+			if MatchChannel(c, e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CloseEvent is an event named close, with the reason as data, to be used as
+// the reason of Eventsource.Disconnect. If Retry is set, it also overrides the
+// time in milliseconds the browser waits before reconnecting.
+type CloseEvent struct {
+	Reason string
+	Retry  int
+}
+
+// Bytes returns the text/stream message with the retry option, if set, and the
+// close event.
+func (e CloseEvent) Bytes() []byte {
+	return Fields{Event: "close", Data: []byte(e.Reason), Retry: e.Retry}.Bytes()
+}
+
+// Match selects all clients.
+func (CloseEvent) Match(Client) bool {
+	return true
+}
+
+// CommentEvent is an SSE comment: browsers ignore it, but it keeps idle
+// connections open through proxies and can annotate the stream for debugging.
+// It is sent to the clients subscribed to at least one of its channels or to
+// all clients if it has no channel.
+type CommentEvent struct {
+	Comment  string
+	Channels []string
+}
+
+// Bytes returns the comment as text/stream comment lines, one per line of the
+// comment, followed by a blank line.
+func (e CommentEvent) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(lineBreaks.Replace(e.Comment), "\n") {
+		buf.WriteString(": ")
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// Match selects clients that have at least one channel in common with the
+// comment or all clients if the comment has no channel.
+func (e CommentEvent) Match(c Client) bool {
+	return DefaultEvent{Channels: e.Channels}.Match(c)
+}
+
+// channels returns the comment channels, see routed.
+func (e CommentEvent) channels() []string {
+	return e.Channels
+}
+
+// RetryEvent changes the time in milliseconds browsers wait before
+// reconnecting, without sending them an event. The server remembers the
+// latest RetryEvent sent to all clients and writes it to new clients too, after
+// the HttpOptions retry, so the reconnect delay can be raised during an
+// incident and lowered back later. With a Broker or a Cluster, retry events
+// are sent to every node. Browsers ignore retries that are not positive, so
+// those events are discarded.
+type RetryEvent struct {
+	Retry int
+}
+
+// Bytes returns the text/stream retry field followed by a blank line, or
+// nothing if the retry is not positive.
+func (e RetryEvent) Bytes() []byte {
+	if e.Retry <= 0 {
+		return nil
+	}
+	return []byte("retry: " + strconv.Itoa(e.Retry) + "\n\n")
+}
+
+// Match selects all clients.
+func (RetryEvent) Match(Client) bool {
+	return true
+}
+
 type ping struct{}
 
 func (ping) Bytes() []byte {
 	return []byte(":ping\n\n")
 }
 
-func (ping) Clients(clients []client) []client {
-	return clients
+func (ping) Match(Client) bool {
+	return true
+}
+
+func (ping) channels() []string {
+	return nil
 }
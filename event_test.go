@@ -12,7 +12,7 @@ var deflated = "eJyqzkyxUjCsBQQAAP//CfUCUQ=="
 func TestDefaultEventBytesWithID(t *testing.T) {
 	expecting := []byte("id: 1\ndata: {id: 1}\n\n")
 	e := DefaultEvent{
-		ID:      1,
+		ID:      "1",
 		Message: message,
 	}
 	result := e.Bytes()
@@ -65,26 +65,26 @@ func TestDefaultEventDeflate(t *testing.T) {
 	}
 }
 
-func TestDefaultEventClientsWithNoChannel(t *testing.T) {
+func TestDefaultEventMatchWithNoChannel(t *testing.T) {
 	client1 := client{channels: []string{"a", "b"}}
 	client2 := client{channels: []string{"c", "d"}}
 	e := DefaultEvent{}
 
 	expecting := []client{client1, client2}
-	result := e.Clients([]client{client1, client2})
+	result := targets(e, []client{client1, client2})
 
 	if !reflect.DeepEqual(expecting, result) {
 		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
 	}
 }
 
-func TestDefaultEventClientsWithChannels(t *testing.T) {
+func TestDefaultEventMatchWithChannels(t *testing.T) {
 	client1 := client{channels: []string{"a", "b"}}
 	client2 := client{channels: []string{"c", "d"}}
 	e := DefaultEvent{Channels: []string{"b", "e"}}
 
 	expected := []client{client1}
-	result := e.Clients([]client{client1, client2})
+	result := targets(e, []client{client1, client2})
 
 	if !reflect.DeepEqual(expected, result) {
 		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expected, result)
@@ -99,11 +99,99 @@ func TestPingBytes(t *testing.T) {
 	}
 }
 
-func TestPingClients(t *testing.T) {
+func TestPingMatch(t *testing.T) {
 	clients := []client{client{}}
 	expecting := clients
-	result := ping{}.Clients(clients)
+	result := targets(ping{}, clients)
 	if !reflect.DeepEqual(expecting, result) {
 		t.Errorf("expected:\n%v\ngot:\n%v\n", expecting, result)
 	}
 }
+
+func TestDefaultEventRecord(t *testing.T) {
+	e := DefaultEvent{ID: "1", Message: message, Channels: []string{"a"}}
+	expecting := Record{ID: "1", Channels: []string{"a"}, Data: e.Bytes()}
+	result := e.Record()
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestDefaultEventRecordWithoutID(t *testing.T) {
+	e := DefaultEvent{Message: message}
+	result := e.Record()
+	if result.ID != "" {
+		t.Errorf("expected record id to be empty\ngot:\n%s\n", result.ID)
+	}
+}
+
+func TestRecordMatch(t *testing.T) {
+	client1 := client{channels: []string{"a", "b"}}
+	client2 := client{channels: []string{"c", "d"}}
+	r := Record{Channels: []string{"c"}}
+
+	expected := []client{client2}
+	result := targets(r, []client{client1, client2})
+
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expected, result)
+	}
+}
+
+func TestDefaultEventMatchWithWildcards(t *testing.T) {
+	client1 := client{channels: []string{"table.*"}}
+	client2 := client{channels: []string{"lobby.>"}}
+	e := DefaultEvent{Channels: []string{"table.1"}}
+
+	expected := []client{client1}
+	result := targets(e, []client{client1, client2})
+
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expected, result)
+	}
+}
+
+func TestCloseEventBytes(t *testing.T) {
+	expecting := []byte("retry: 60000\nevent: close\ndata: banned\n\n")
+	result := CloseEvent{Reason: "banned", Retry: 60000}.Bytes()
+	if !bytes.Equal(expecting, result) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+}
+
+func TestCloseEventBytesWithoutRetry(t *testing.T) {
+	expecting := []byte("event: close\ndata: banned\n\n")
+	result := CloseEvent{Reason: "banned"}.Bytes()
+	if !bytes.Equal(expecting, result) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+}
+
+func TestCommentEventBytes(t *testing.T) {
+	expecting := []byte(": deploy 42\n: rolling\n\n")
+	result := CommentEvent{Comment: "deploy 42\r\nrolling"}.Bytes()
+	if !bytes.Equal(expecting, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, result)
+	}
+}
+
+func TestCommentEventMatch(t *testing.T) {
+	client1 := client{channels: []string{"a"}}
+	client2 := client{channels: []string{"b"}}
+	expecting := []client{client2}
+	result := targets(CommentEvent{Channels: []string{"b"}}, []client{client1, client2})
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\ngot:\n%v\n", expecting, result)
+	}
+}
+
+func TestRetryEventBytes(t *testing.T) {
+	expecting := []byte("retry: 30000\n\n")
+	result := RetryEvent{Retry: 30000}.Bytes()
+	if !bytes.Equal(expecting, result) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+	if result := (RetryEvent{Retry: -1}).Bytes(); len(result) > 0 {
+		t.Errorf("expected negative retry to write nothing\ngot:\n%q\n", result)
+	}
+}
@@ -1,7 +1,10 @@
 package eventsource
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -15,18 +18,108 @@ type Eventsource struct {
 	// defaults to NoChannels, meaning all events must be global.
 	ChannelSubscriber
 
+	// Interface that implements how the identity of the user behind a client
+	// is found, to send events to all connections of a user. It defaults to
+	// NoIdentity.
+	IdentityResolver
+
+	// Interface that implements which connections are accepted, before they
+	// are hijacked. It defaults to NoAuthorization.
+	Authorizer
+
 	// Interface that implements what options are sent during the initial http
 	// handshaking. See DefaultHttpOptions for built-in options.
 	HttpOptions
 
 	// Interface that implements basic metrics for events
 	Metrics
+
+	// Interface that implements where sent events are stored to be replayed
+	// to clients reconnecting with a Last-Event-ID header. See MemoryStore
+	// and FileStore for built-in stores. It defaults to no store, unless
+	// HistorySize is set.
+	EventStore
+
+	// Streaming makes ServeHTTP keep the http.ResponseWriter instead of
+	// hijacking the connection, writing events through http.Flusher. This
+	// mode works over HTTP/2 and with wrapped response writers. Clients are
+	// removed when their request context is done.
+	Streaming bool
+
+	// QueueSize is the number of events each client can have waiting to be
+	// written. It defaults to DefaultQueueSize.
+	QueueSize int
+
+	// Overflow is the policy applied when an event is sent to a client whose
+	// queue is full. It defaults to DropOldest.
+	Overflow OverflowPolicy
+
+	// WriteTimeout is how long a client has to write an event before its
+	// connection is considered broken. It defaults to DefaultWriteTimeout.
+	WriteTimeout time.Duration
+
+	// HistorySize is the number of events kept in memory for each channel,
+	// and for global events, when no EventStore is set. Only events with an
+	// ID are kept. Zero disables the history.
+	HistorySize int
+
+	// Limits caps the number of clients accepted, see Limits. It defaults to
+	// no limits.
+	Limits Limits
+
+	// PresenceChannels are the channels, or wildcard subscriptions matching
+	// them, whose members are tracked. Clients with an identity joining or
+	// leaving these channels make the server send MemberJoinedEvent and
+	// MemberLeftEvent events to the channel, see Members.
+	PresenceChannels []string
+
+	// OnConnect is called when a client is added to the server.
+	OnConnect func(Client)
+
+	// OnDisconnect is called when a client is removed from the server, with
+	// the reason it was removed. Hooks are called in order from their own
+	// goroutine, so they can call the eventsource but a slow hook delays the
+	// next ones.
+	OnDisconnect func(Client, DisconnectReason)
+
+	// OnChannelOpen is called when a channel gains its first subscriber, and
+	// OnChannelClose when it loses its last one or when the eventsource is
+	// shut down. Wildcard subscriptions are channels of their own. Like the
+	// client hooks, they are called in order from their own goroutine.
+	OnChannelOpen  func(channel string)
+	OnChannelClose func(channel string)
+
+	// ChannelGrace delays OnChannelClose, which is not called if the channel
+	// gains a subscriber again during the delay.
+	ChannelGrace time.Duration
+
+	// Broker carries events between nodes, so events sent on this node reach
+	// the clients of every node. See MemoryBroker and TCPBroker for built-in
+	// brokers. It defaults to no broker, sending events to local clients only.
+	Broker Broker
+
+	// Cluster shards channels between nodes, instead of sending every event
+	// to every node through the Broker. See Cluster.
+	Cluster *Cluster
+
+	// IDGenerator stamps an id on Identifiable events sent without one, so
+	// every event can be replayed to reconnecting browsers. It defaults to no
+	// generator, sending events as they are. See CounterIDs and ULIDs.
+	IDGenerator IDGenerator
 }
 
 // A HijackingError is displayed when the browser doesn't support connection
 // hijacking. See http://golang.org/pkg/net/http/#Hijacker
 var HijackingError = "webserver doesn't support hijacking"
 
+// A FlushingError is displayed when the browser doesn't support flushing in
+// streaming mode. See http://golang.org/pkg/net/http/#Flusher
+var FlushingError = "webserver doesn't support flushing"
+
+// A ShutdownError is displayed when a connection arrives after the eventsource
+// has started shutting down.
+var ShutdownError = "eventsource is shutting down"
+
 // Start sets all undefined options to their defaults and configure the
 // underlining server to start listening to events
 func (es *Eventsource) Start() {
@@ -34,6 +127,14 @@ func (es *Eventsource) Start() {
 		es.ChannelSubscriber = NoChannels{}
 	}
 
+	if es.IdentityResolver == nil {
+		es.IdentityResolver = NoIdentity{}
+	}
+
+	if es.Authorizer == nil {
+		es.Authorizer = NoAuthorization{}
+	}
+
 	if es.HttpOptions == nil {
 		es.HttpOptions = DefaultHttpOptions{
 			Retry:             2000,
@@ -46,34 +147,246 @@ func (es *Eventsource) Start() {
 		es.Metrics = DefaultMetrics{}
 	}
 
+	if es.EventStore == nil && es.HistorySize > 0 {
+		es.EventStore = NewMemoryStore(es.HistorySize)
+	}
+
 	es.server = server{
 		add:      make(chan client),
 		remove:   make(chan client),
 		events:   make(chan Event),
+		subs:     make(chan subscription),
+		kicks:    make(chan kick),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		stopOnce: &sync.Once{},
 		hearbeat: 30 * time.Second,
 		metrics:  es.Metrics,
+		store:    es.EventStore,
+		overflow: es.Overflow,
+		limiter:  newLimiter(es.Limits),
+		presence: newPresence(es.PresenceChannels),
+
+		onConnect:      es.OnConnect,
+		onDisconnect:   es.OnDisconnect,
+		onChannelOpen:  es.OnChannelOpen,
+		onChannelClose: es.OnChannelClose,
+	}
+
+	if es.OnChannelOpen != nil || es.OnChannelClose != nil {
+		es.server.lifecycle = newLifecycle(es.ChannelGrace, es.server.stop)
+	}
+
+	if es.OnConnect != nil || es.OnDisconnect != nil || es.server.lifecycle != nil {
+		es.server.dispatcher = newDispatcher()
 	}
 
 	go es.server.listen()
+
+	if es.Broker != nil {
+		es.Broker.Subscribe(es.receive)
+	}
+
+	if es.Cluster != nil && es.Cluster.Forwarder != nil {
+		es.Cluster.Forwarder.Subscribe(es.receive)
+	}
 }
 
-// Send forwards an event to clients
+// Send forwards an event to clients. Events sent after the eventsource has
+// been shut down are discarded. With a Broker, events are also published to
+// the other nodes; events that can't be recorded, see Recordable, only reach
+// local clients, except retry events. With a Cluster, events are sent to the
+// nodes owning their channels instead. With an IDGenerator, events without an
+// id are stamped first, so every node sees the same id.
 func (es *Eventsource) Send(event Event) {
-	es.events <- event
+	es.send(context.Background(), event)
+}
+
+// send forwards an event to clients, like Send, giving up when the context is
+// done before the server receives the event. It returns the context error if
+// the event was not delivered to local clients nor published.
+func (es *Eventsource) send(ctx context.Context, event Event) error {
+	if r, ok := unwrap(event).(RetryEvent); ok && r.Retry <= 0 {
+		return nil
+	}
+	if es.IDGenerator != nil {
+		event = stamp(event, es.IDGenerator)
+	}
+	if es.Cluster != nil {
+		return es.forward(ctx, event)
+	}
+	if err := es.deliver(ctx, event); err != nil {
+		return err
+	}
+	if es.Broker == nil {
+		return nil
+	}
+	m, ok := newMessage(event)
+	if !ok {
+		return nil
+	}
+	if err := es.Broker.Publish(m); err != nil {
+		log.Printf("Broker publish failed - %s\n", err)
+	}
+	return nil
+}
+
+// forward delivers an event to local clients if this node owns it and
+// forwards it to the other nodes owning it. Events that can't be recorded are
+// only delivered to local clients.
+func (es *Eventsource) forward(ctx context.Context, event Event) error {
+	m, ok := newMessage(event)
+	if !ok {
+		return es.deliver(ctx, event)
+	}
+	for _, node := range es.Cluster.destinations(event) {
+		if node == es.Cluster.Self {
+			if err := es.deliver(ctx, event); err != nil {
+				return err
+			}
+			continue
+		}
+		if es.Cluster.Forwarder == nil {
+			continue
+		}
+		if err := es.Cluster.Forwarder.Forward(node, m); err != nil {
+			log.Printf("Cluster forward to %s failed - %s\n", node, err)
+		}
+	}
+	return nil
+}
+
+// deliver forwards an event to local clients. Events are discarded once the
+// eventsource has been shut down; it returns the context error if the context
+// is done first.
+func (es *Eventsource) deliver(ctx context.Context, event Event) error {
+	select {
+	case es.events <- event:
+		return nil
+	case <-es.stop:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// receive delivers a message published by another node to local clients.
+func (es *Eventsource) receive(m Message) {
+	es.deliver(context.Background(), m.event())
+}
+
+// SendTo forwards an event only to the client with the id passed in, see
+// Client.ID. The event channels are ignored.
+func (es *Eventsource) SendTo(clientID string, event Event) {
+	es.Send(direct{event: event, id: clientID})
+}
+
+// SendToUser forwards an event to every client of the user with the identity
+// passed in, see IdentityResolver. The event channels are ignored.
+func (es *Eventsource) SendToUser(identity string, event Event) {
+	es.Send(personal{event: event, identity: identity})
+}
+
+// Subscribe adds channels to the connected clients chosen by the selector.
+// Clients start receiving events sent to those channels right away, without
+// reconnecting. With a Cluster, channels owned by another node are refused.
+func (es *Eventsource) Subscribe(selector Selector, channels ...string) {
+	if es.Cluster != nil {
+		channels = es.Cluster.owned(channels)
+	}
+	es.updateSubscriptions(subscription{selector: selector, channels: channels})
+}
+
+// Unsubscribe removes channels from the connected clients chosen by the
+// selector.
+func (es *Eventsource) Unsubscribe(selector Selector, channels ...string) {
+	es.updateSubscriptions(subscription{selector: selector, channels: channels, remove: true})
+}
+
+// updateSubscriptions forwards a subscription change to the server.
+func (es *Eventsource) updateSubscriptions(sub subscription) {
+	select {
+	case es.subs <- sub:
+	case <-es.stop:
+	}
+}
+
+// Members returns the sorted identities of the users with a client subscribed
+// to a presence channel.
+func (es *Eventsource) Members(channel string) []string {
+	return es.presence.list(channel)
+}
+
+// Disconnect closes the connection of the clients chosen by the selector,
+// discarding their queued events. If reason is not nil, it is written to the
+// clients right before their connection is closed, see CloseEvent.
+func (es *Eventsource) Disconnect(selector Selector, reason Event) {
+	k := kick{selector: selector}
+	if reason != nil {
+		k.data = reason.Bytes()
+	}
+	select {
+	case es.kicks <- k:
+	case <-es.stop:
+	}
+}
+
+// Shutdown gracefully stops the eventsource. New connections are refused,
+// events already sent are written to clients, then all client connections and
+// the heartbeat are closed. Shutdown returns once every client has finished or
+// when the context is done, whichever happens first.
+func (es *Eventsource) Shutdown(ctx context.Context) error {
+	if es.stop == nil {
+		return nil
+	}
+	es.stopOnce.Do(func() { close(es.stop) })
+	select {
+	case <-es.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop shuts down the eventsource, waiting for all clients to be disconnected.
+func (es *Eventsource) Stop() {
+	es.Shutdown(context.Background())
 }
 
 // ServeHTTP implements the http handle interface.
-// If the connection supports hijacking, it sends an initial header and body to
-// switch to the text/stream protocol and start streaming.
+// Connections to channels owned by another node of the Cluster are redirected
+// to it. Connections rejected by the Authorizer or over the Limits are answered
+// with an http error. If the connection supports hijacking, it sends an initial
+// header and body to switch to the text/stream protocol and start streaming.
+// In streaming mode, the header and body are written to the response writer
+// instead.
 func (es *Eventsource) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	select {
+	case <-es.stop:
+		http.Error(res, ShutdownError, http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	if es.Streaming {
+		es.stream(res, req)
+		return
+	}
+
 	hj, ok := res.(http.Hijacker)
 	if !ok {
 		http.Error(res, HijackingError, http.StatusInternalServerError)
 		return
 	}
 
+	c, ok := es.accept(res, req)
+	if !ok {
+		return
+	}
+
 	conn, _, err := hj.Hijack()
 	if err != nil {
+		es.limiter.release(c.id)
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -81,18 +394,146 @@ func (es *Eventsource) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	options := es.HttpOptions.Bytes(req)
 	_, err = conn.Write(options)
 	if err != nil {
+		es.limiter.release(c.id)
 		conn.Close()
 		return
 	}
 
-	channels := es.ChannelSubscriber.ParseRequest(req)
+	c.conn = conn
+	es.join(c)
+}
+
+// stream keeps the response writer open, writing events to it until the
+// request context is done or the client is disconnected.
+func (es *Eventsource) stream(res http.ResponseWriter, req *http.Request) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		http.Error(res, FlushingError, http.StatusInternalServerError)
+		return
+	}
+
+	c, ok := es.accept(res, req)
+	if !ok {
+		return
+	}
+
+	err := writeOptions(res, req, es.HttpOptions.Bytes(req))
+	if err != nil {
+		es.limiter.release(c.id)
+		return
+	}
+	flusher.Flush()
+
+	c.conn = newFlushWriter(res, flusher)
+	c.gone = req.Context().Done()
+	if es.join(c) {
+		<-c.done
+	}
+}
+
+// accept creates the client of a request. Clients of channels owned by
+// another node of the cluster are redirected to it; other clients are checked
+// by the Authorizer and the limits. Accepted clients have their backlog read
+// from the EventStore. If the client is not accepted, the request is answered
+// and accept returns false.
+func (es *Eventsource) accept(res http.ResponseWriter, req *http.Request) (client, bool) {
+	c := es.newClient(req)
+	if es.Cluster != nil {
+		node, err := es.Cluster.home(c.channels)
+		if err != nil {
+			reject(res, err)
+			return c, false
+		}
+		if node != es.Cluster.Self {
+			location, err := es.Cluster.redirect(node, req)
+			if err != nil {
+				reject(res, err)
+				return c, false
+			}
+			http.Redirect(res, req, location, http.StatusTemporaryRedirect)
+			return c, false
+		}
+	}
+	if err := es.authorize(req, &c); err != nil {
+		reject(res, err)
+		return c, false
+	}
+	if err := es.admit(req, c); err != nil {
+		reject(res, err)
+		return c, false
+	}
+	return es.prefetch(c), true
+}
+
+// admit checks the limits for a new client. When the user of the client has
+// too many connections and the oldest one must be evicted, it is disconnected
+// with a close event, or as soon as it joins the server if it is still
+// connecting.
+func (es *Eventsource) admit(req *http.Request, c client) error {
+	evicted, err := es.limiter.admit(ticket{
+		id:       c.id,
+		ip:       es.limiter.remoteIP(req),
+		identity: c.identity,
+		channels: c.channels,
+	})
+	if err != nil {
+		return err
+	}
+	if evicted != "" {
+		es.Disconnect(ByID(evicted), CloseEvent{Reason: TooManyConnectionsError})
+	}
+	return nil
+}
+
+// reject answers the http request with the status of the error passed in.
+func reject(res http.ResponseWriter, err error) {
+	if e, ok := err.(StatusError); ok {
+		http.Error(res, e.Message, e.Code)
+		return
+	}
+	http.Error(res, err.Error(), http.StatusInternalServerError)
+}
 
-	c := client{
-		conn:     conn,
-		channels: channels,
-		events:   make(chan payload),
-		done:     make(chan bool),
+// newClient creates a client subscribed to the channels parsed from the
+// request. Its connection is set once the client is admitted. Options left
+// undefined, when the eventsource wasn't started, use their defaults.
+func (es *Eventsource) newClient(req *http.Request) client {
+	size := es.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	resolver := es.IdentityResolver
+	if resolver == nil {
+		resolver = NoIdentity{}
 	}
+	subscriber := es.ChannelSubscriber
+	if subscriber == nil {
+		subscriber = NoChannels{}
+	}
+	return client{
+		id:          newID(),
+		identity:    resolver.Identity(req),
+		remoteAddr:  req.RemoteAddr,
+		header:      req.Header,
+		query:       req.URL.Query(),
+		channels:    subscriber.ParseRequest(req),
+		events:      make(chan payload, size),
+		done:        make(chan bool),
+		quit:        make(chan []byte, 1),
+		timeout:     es.WriteTimeout,
+		lastEventID: req.Header.Get("Last-Event-ID"),
+	}
+}
 
-	es.server.add <- c
+// join adds a client to the server. If the eventsource is shutting down, the
+// client connection is closed instead and join returns false.
+func (es *Eventsource) join(c client) bool {
+	select {
+	case es.server.add <- c:
+		return true
+	case <-es.stop:
+		es.limiter.release(c.id)
+		c.conn.Close()
+		return false
+	}
 }
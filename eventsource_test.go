@@ -3,6 +3,8 @@ package eventsource
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -105,6 +107,9 @@ func TestEventsourceServeHTTP(t *testing.T) {
 			if c.conn == nil {
 				t.Errorf("expecting client connection to be assigned")
 			}
+			if c.id == "" {
+				t.Errorf("expecting client id to be assigned")
+			}
 			if c.events == nil {
 				t.Errorf("expecting client events chan to be open")
 			}
@@ -184,3 +189,261 @@ func TestEventsourceServeHTTPConnectionClosed(t *testing.T) {
 		t.Errorf("expected connection to be closed")
 	}
 }
+
+func TestEventsourceShutdown(t *testing.T) {
+	es := &Eventsource{Metrics: NoopMetrics{}}
+	es.Start()
+	server := httptest.NewServer(es)
+	defer server.Close()
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	reader := bufio.NewReader(res.Body)
+	reader.ReadBytes('\n')
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if err := es.Shutdown(ctx); err != nil {
+		t.Errorf("expected shutdown to succeed\ngot:\n%s\n", err)
+	}
+	_, err = io.ReadAll(reader)
+	if err != nil {
+		t.Errorf("expected connection to be closed\ngot:\n%s\n", err)
+	}
+}
+
+func TestEventsourceShutdownRefusesConnections(t *testing.T) {
+	es := &Eventsource{Metrics: NoopMetrics{}}
+	es.Start()
+	es.Stop()
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	es.ServeHTTP(w, r)
+	errCode := 503
+	code := w.Code
+	if errCode != code {
+		t.Errorf("expected:\n%d\nto be equal to:\n%d\n", errCode, code)
+	}
+}
+
+func TestEventsourceShutdownNotStarted(t *testing.T) {
+	es := Eventsource{}
+	err := es.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("expected shutdown to succeed\ngot:\n%s\n", err)
+	}
+}
+
+func TestEventsourceReplay(t *testing.T) {
+	es := &Eventsource{Metrics: NoopMetrics{}, HistorySize: 10}
+	es.Start()
+	defer es.Stop()
+	missed := DefaultEvent{ID: "2", Message: message}
+	es.Send(DefaultEvent{ID: "1", Message: message})
+	es.Send(missed)
+	server := httptest.NewServer(es)
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+	expecting := missed.Bytes()
+	result := make([]byte, len(expecting))
+	reader := bufio.NewReader(res.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if bytes.HasPrefix(line, []byte("id:")) {
+			copy(result, line)
+			io.ReadFull(reader, result[len(line):])
+			break
+		}
+	}
+	if !bytes.Equal(expecting, result) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+}
+
+func TestEventsourceStartHistorySize(t *testing.T) {
+	es := Eventsource{HistorySize: 10}
+	es.Start()
+	store, ok := es.EventStore.(*MemoryStore)
+	if !ok {
+		t.Errorf("expected to be *MemoryStore\ngot:\n%T\n", store)
+	}
+}
+
+type noFlusher struct {
+	http.ResponseWriter
+}
+
+func TestEventsourceStreamingFlushingNotSupported(t *testing.T) {
+	es := Eventsource{Streaming: true}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	es.ServeHTTP(noFlusher{w}, r)
+	errCode := 500
+	code := w.Code
+	if errCode != code {
+		t.Errorf("expected:\n%d\nto be equal to:\n%d\n", errCode, code)
+	}
+
+	errMsg := []byte(FlushingError + "\n")
+	msg := w.Body.Bytes()
+
+	if !bytes.Equal(errMsg, msg) {
+		t.Errorf("expected:\n%s\nto be equal to:\n%s\n", errMsg, msg)
+	}
+}
+
+func TestEventsourceStreamingHTTP2(t *testing.T) {
+	es := &Eventsource{Metrics: NoopMetrics{}, Streaming: true}
+	es.Start()
+	defer es.Stop()
+	server := httptest.NewUnstartedServer(es)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Errorf("expected:\nHTTP/2\ngot:\n%s\n", res.Proto)
+	}
+	expecting := "text/event-stream"
+	if result := res.Header.Get("Content-Type"); expecting != result {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+
+	e := DefaultEvent{Message: message}
+	go func() {
+		for {
+			select {
+			case <-time.After(10 * time.Millisecond):
+				es.Send(e)
+			case <-es.stop:
+				return
+			}
+		}
+	}()
+	reader := bufio.NewReader(res.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if bytes.HasPrefix(line, []byte("data:")) {
+			break
+		}
+	}
+}
+
+func TestEventsourceStreamingRequestDone(t *testing.T) {
+	es := &Eventsource{Metrics: NoopMetrics{}, Streaming: true}
+	es.Start()
+	defer es.Stop()
+	w := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+	done := make(chan bool)
+	go func() {
+		es.ServeHTTP(w, r)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Errorf("expected handler to return when the request is done")
+	}
+}
+
+func TestEventsourceSubscribe(t *testing.T) {
+	es := Eventsource{}
+	subs := make(chan subscription, 2)
+	es.server = server{subs: subs}
+	es.Subscribe(ByID("a"), "x", "y")
+	es.Unsubscribe(ByID("a"), "x")
+
+	result := <-subs
+	if result.remove || !reflect.DeepEqual([]string{"x", "y"}, result.channels) {
+		t.Errorf("expected subscription to x and y\ngot:\n%v\n", result)
+	}
+	result = <-subs
+	if !result.remove || !reflect.DeepEqual([]string{"x"}, result.channels) {
+		t.Errorf("expected unsubscription from x\ngot:\n%v\n", result)
+	}
+}
+
+func TestEventsourceStartNoIdentity(t *testing.T) {
+	es := Eventsource{}
+	es.Start()
+	result, ok := es.IdentityResolver.(NoIdentity)
+	if !ok {
+		t.Errorf("expected to be NoIdentity\ngot:\n%T\n", result)
+	}
+}
+
+func TestEventsourceSendTo(t *testing.T) {
+	es := Eventsource{}
+	events := make(chan Event, 2)
+	es.server = server{events: events}
+	e := DefaultEvent{Name: "test"}
+	es.SendTo("a", e)
+	es.SendToUser("42", e)
+
+	expecting := []Event{direct{event: e, id: "a"}, personal{event: e, identity: "42"}}
+	result := []Event{<-events, <-events}
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestEventsourceDisconnect(t *testing.T) {
+	es := Eventsource{}
+	kicks := make(chan kick, 2)
+	es.server = server{kicks: kicks}
+	reason := CloseEvent{Reason: "banned"}
+	es.Disconnect(ByID("a"), reason)
+	es.Disconnect(ByID("b"), nil)
+
+	result := <-kicks
+	if !bytes.Equal(reason.Bytes(), result.data) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", reason.Bytes(), result.data)
+	}
+	result = <-kicks
+	if result.data != nil {
+		t.Errorf("expected kick without data\ngot:\n%s\n", result.data)
+	}
+}
+
+func TestEventsourceLimits(t *testing.T) {
+	es := &Eventsource{Metrics: NoopMetrics{}, Limits: Limits{MaxClientsPerIP: 1}}
+	es.Start()
+	defer es.Stop()
+	server := httptest.NewServer(es)
+	defer server.Close()
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+
+	res, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected:\n%d\ngot:\n%d\n", http.StatusTooManyRequests, res.StatusCode)
+	}
+}
@@ -0,0 +1,269 @@
+package eventsource
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultSegmentSize is the size in bytes after which a FileStore starts
+	// writing to a new segment.
+	DefaultSegmentSize = 16 << 20
+
+	// DefaultSegments is the number of segments a FileStore keeps on disk.
+	DefaultSegments = 8
+)
+
+// FileStore implements the EventStore interface persisting records to an
+// append-only log on disk, so the replay window survives process restarts.
+// The log is split in segment files inside a directory; when the current
+// segment grows over the segment size a new one is created, and the oldest
+// segments are deleted to keep at most the configured number of segments.
+// The position of each record is kept in memory, so Since reads the log from
+// the record a client last received instead of reading every segment.
+type FileStore struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	maxSegments int
+	segments    []int
+	positions   map[string]position
+	file        *os.File
+	size        int64
+}
+
+// A position is where a record starts in the log.
+type position struct {
+	segment int
+	offset  int64
+}
+
+// A fileRecord is the on-disk representation of a record, one JSON document
+// per line.
+type fileRecord struct {
+	ID       string   `json:"id"`
+	Channels []string `json:"channels,omitempty"`
+	Data     []byte   `json:"data"`
+}
+
+// NewFileStore opens, creating it if needed, a FileStore in the directory dir.
+// Zero values for segmentSize and segments are replaced by DefaultSegmentSize
+// and DefaultSegments. Existing segments are kept and new records are always
+// written to a new segment.
+func NewFileStore(dir string, segmentSize int64, segments int) (*FileStore, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if segments <= 0 {
+		segments = DefaultSegments
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	existing, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FileStore{
+		dir:         dir,
+		segmentSize: segmentSize,
+		maxSegments: segments,
+		segments:    existing,
+		positions:   make(map[string]position),
+	}
+	for _, n := range existing {
+		err := readSegment(fs.path(n), 0, func(r Record, offset int64) {
+			fs.positions[r.ID] = position{segment: n, offset: offset}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := fs.roll(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Append writes a record at the end of the current segment. Records without an
+// ID are ignored.
+func (fs *FileStore) Append(r Record) error {
+	if r.ID == "" {
+		return nil
+	}
+	line, err := json.Marshal(fileRecord{ID: r.ID, Channels: r.Channels, Data: r.Data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.file == nil {
+		return os.ErrClosed
+	}
+	if fs.size > 0 && fs.size+int64(len(line)) > fs.segmentSize {
+		if err := fs.roll(); err != nil {
+			return err
+		}
+	}
+	fs.positions[r.ID] = position{segment: fs.segments[len(fs.segments)-1], offset: fs.size}
+	n, err := fs.file.Write(line)
+	fs.size += int64(n)
+	return err
+}
+
+// Since reads the segments from the position of the last record with the id
+// passed in, returning the records appended after it that a client subscribed
+// to channels should receive. The log is read without holding the lock, so
+// appends are not blocked by slow reads; records being written are skipped
+// as truncated ones.
+func (fs *FileStore) Since(id string, channels []string) ([]Record, error) {
+	fs.mu.Lock()
+	from, ok := fs.positions[id]
+	var segments []int
+	for _, n := range fs.segments {
+		if n >= from.segment {
+			segments = append(segments, n)
+		}
+	}
+	fs.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	var records []Record
+	for _, n := range segments {
+		var offset int64
+		if n == from.segment {
+			offset = from.offset
+		}
+		err := readSegment(fs.path(n), offset, func(r Record, at int64) {
+			if n == from.segment && at == from.offset {
+				return
+			}
+			if len(r.Channels) == 0 || subscribes(channels, r.Channels) {
+				records = append(records, r)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Close closes the current segment. Appending to a closed FileStore fails.
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
+
+// roll closes the current segment and creates a new one, deleting the oldest
+// segments over the maximum number of segments.
+func (fs *FileStore) roll() error {
+	if fs.file != nil {
+		if err := fs.file.Close(); err != nil {
+			return err
+		}
+	}
+	next := 1
+	if len(fs.segments) > 0 {
+		next = fs.segments[len(fs.segments)-1] + 1
+	}
+	file, err := os.OpenFile(fs.path(next), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fs.file = file
+	fs.size = 0
+
+	fs.segments = append(fs.segments, next)
+	for len(fs.segments) > fs.maxSegments {
+		err := os.Remove(fs.path(fs.segments[0]))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for id, p := range fs.positions {
+			if p.segment == fs.segments[0] {
+				delete(fs.positions, id)
+			}
+		}
+		fs.segments = fs.segments[1:]
+	}
+	return nil
+}
+
+// path returns the file name of the segment n.
+func (fs *FileStore) path(n int) string {
+	return filepath.Join(fs.dir, fmt.Sprintf("%020d.log", n))
+}
+
+// listSegments returns the sorted segment numbers found in dir.
+func listSegments(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(name, ".log"))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, n)
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// readSegment calls fn for each record in the segment file, starting at the
+// offset, with the offset of the record. A truncated last line, left by a
+// crash in the middle of a write, is ignored.
+func readSegment(path string, offset int64, fn func(Record, int64)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var r fileRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("eventsource: corrupted segment %s: %s", path, err)
+		}
+		fn(Record{ID: r.ID, Channels: r.Channels, Data: r.Data}, offset)
+		offset += int64(len(line))
+	}
+}
@@ -0,0 +1,142 @@
+package eventsource
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestFileStoreSince(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer fs.Close()
+	r1 := Record{ID: "1", Data: []byte("1")}
+	r2 := Record{ID: "2", Channels: []string{"a"}, Data: []byte("2")}
+	r3 := Record{ID: "3", Channels: []string{"b"}, Data: []byte("3")}
+	r4 := Record{ID: "4", Data: []byte("4")}
+	for _, r := range []Record{r1, r2, r3, r4} {
+		fs.Append(r)
+	}
+
+	expecting := []Record{r2, r4}
+	result, err := fs.Since("1", []string{"a"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestFileStoreSinceUnknownID(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir(), 0, 0)
+	defer fs.Close()
+	fs.Append(Record{ID: "1", Data: []byte("1")})
+	result, _ := fs.Since("0", nil)
+	if len(result) > 0 {
+		t.Errorf("expected:\n%v\nto be empty\n", result)
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	fs, _ := NewFileStore(dir, 0, 0)
+	r1 := Record{ID: "1", Data: []byte("1")}
+	r2 := Record{ID: "2", Data: []byte("2")}
+	fs.Append(r1)
+	fs.Append(r2)
+	fs.Close()
+
+	fs, err := NewFileStore(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer fs.Close()
+	r3 := Record{ID: "3", Data: []byte("3")}
+	fs.Append(r3)
+
+	expecting := []Record{r2, r3}
+	result, _ := fs.Since("1", nil)
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestFileStoreSegments(t *testing.T) {
+	dir := t.TempDir()
+	fs, _ := NewFileStore(dir, 1, 2)
+	defer fs.Close()
+	r1 := Record{ID: "1", Data: []byte("1")}
+	r2 := Record{ID: "2", Data: []byte("2")}
+	r3 := Record{ID: "3", Data: []byte("3")}
+	for _, r := range []Record{r1, r2, r3} {
+		fs.Append(r)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("expected:\n2 segments\ngot:\n%d\n", len(entries))
+	}
+	if result, _ := fs.Since("1", nil); len(result) > 0 {
+		t.Errorf("expected deleted segment to replay nothing\ngot:\n%v\n", result)
+	}
+	expecting := []Record{r3}
+	result, _ := fs.Since("2", nil)
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestFileStoreTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	fs, _ := NewFileStore(dir, 0, 0)
+	defer fs.Close()
+	r1 := Record{ID: "1", Data: []byte("1")}
+	r2 := Record{ID: "2", Data: []byte("2")}
+	fs.Append(r1)
+	fs.Append(r2)
+	fs.file.WriteString(`{"id":"3","da`)
+
+	expecting := []Record{r2}
+	result, err := fs.Since("1", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestFileStoreClosed(t *testing.T) {
+	fs, _ := NewFileStore(t.TempDir(), 0, 0)
+	fs.Close()
+	err := fs.Append(Record{ID: "1"})
+	if err != os.ErrClosed {
+		t.Errorf("expected:\n%s\ngot:\n%v\n", os.ErrClosed, err)
+	}
+}
+
+func TestFileStoreSinceAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+	fs, _ := NewFileStore(dir, 64, 20)
+	var records []Record
+	for i := 1; i <= 10; i++ {
+		r := Record{ID: strconv.Itoa(i), Channels: []string{"a"}, Data: []byte("data")}
+		records = append(records, r)
+		fs.Append(r)
+	}
+	fs.Close()
+
+	fs, _ = NewFileStore(dir, 64, 20)
+	defer fs.Close()
+	result, err := fs.Since("4", []string{"a"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(records[4:], result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", records[4:], result)
+	}
+}
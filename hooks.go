@@ -0,0 +1,142 @@
+package eventsource
+
+import "sync"
+
+// A DisconnectReason tells why a client was removed from the server.
+type DisconnectReason int
+
+const (
+	// ClientGone means the browser went away, which is only detected in
+	// streaming mode when the request context is done.
+	ClientGone DisconnectReason = iota
+
+	// WriteError means writing an event to the connection failed.
+	WriteError
+
+	// WriteTimeout means writing an event took longer than the write timeout.
+	WriteTimeout
+
+	// HeartbeatFailure means writing the periodic ping failed.
+	HeartbeatFailure
+
+	// Overflowed means the client queue was full with the DisconnectClient
+	// overflow policy.
+	Overflowed
+
+	// Kicked means the client was selected by Eventsource.Disconnect.
+	Kicked
+
+	// Expired means the client principal has expired.
+	Expired
+
+	// Shutdown means the eventsource was shut down.
+	Shutdown
+)
+
+var reasons = [...]string{
+	ClientGone:       "client gone",
+	WriteError:       "write error",
+	WriteTimeout:     "write timeout",
+	HeartbeatFailure: "heartbeat failure",
+	Overflowed:       "queue overflowed",
+	Kicked:           "kicked",
+	Expired:          "expired",
+	Shutdown:         "shutdown",
+}
+
+func (r DisconnectReason) String() string {
+	if r < 0 || int(r) >= len(reasons) {
+		return "unknown"
+	}
+	return reasons[r]
+}
+
+// A dispatcher runs hooks one at a time, in the order they are dispatched, in
+// its own goroutine. Dispatching never blocks, so slow hooks or hooks calling
+// back into the eventsource can't block the server listen loop. A nil
+// dispatcher discards hooks.
+type dispatcher struct {
+	mu     sync.Mutex
+	queue  []func()
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+func newDispatcher() *dispatcher {
+	d := &dispatcher{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// dispatch queues a hook to be run.
+func (d *dispatcher) dispatch(hook func()) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.queue = append(d.queue, hook)
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the dispatcher once every queued hook has run, waiting for it.
+func (d *dispatcher) close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	<-d.done
+}
+
+func (d *dispatcher) run() {
+	defer close(d.done)
+	for range d.wake {
+		for {
+			d.mu.Lock()
+			if len(d.queue) == 0 {
+				closed := d.closed
+				d.mu.Unlock()
+				if closed {
+					return
+				}
+				break
+			}
+			hook := d.queue[0]
+			d.queue[0] = nil
+			d.queue = d.queue[1:]
+			d.mu.Unlock()
+			hook()
+		}
+	}
+}
+
+// connected runs the OnConnect hook for a client added to the server.
+func (s server) connected(c client) {
+	if s.onConnect == nil {
+		return
+	}
+	view := c.view()
+	s.dispatcher.dispatch(func() { s.onConnect(view) })
+}
+
+// disconnected runs the OnDisconnect hook for a client removed from the server.
+func (s server) disconnected(c client, reason DisconnectReason) {
+	if s.onDisconnect == nil {
+		return
+	}
+	view := c.view()
+	s.dispatcher.dispatch(func() { s.onDisconnect(view, reason) })
+}
@@ -0,0 +1,155 @@
+package eventsource
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDisconnectReasonString(t *testing.T) {
+	if Kicked.String() != "kicked" {
+		t.Errorf("expected:\nkicked\ngot:\n%s\n", Kicked)
+	}
+	if DisconnectReason(42).String() != "unknown" {
+		t.Errorf("expected:\nunknown\ngot:\n%s\n", DisconnectReason(42))
+	}
+}
+
+func TestDispatcherOrder(t *testing.T) {
+	d := newDispatcher()
+	var result []int
+	block := make(chan bool)
+	d.dispatch(func() { <-block })
+	for i := 0; i < 100; i++ {
+		i := i
+		d.dispatch(func() { result = append(result, i) })
+	}
+	close(block)
+	d.close()
+	if len(result) != 100 {
+		t.Fatalf("expected:\n100 hooks\ngot:\n%d\n", len(result))
+	}
+	for i, n := range result {
+		if i != n {
+			t.Fatalf("expected hooks to run in order\ngot:\n%v\n", result)
+		}
+	}
+}
+
+func TestDispatcherNil(t *testing.T) {
+	var d *dispatcher
+	d.dispatch(func() { t.Errorf("expected hook to be discarded") })
+	d.close()
+}
+
+func TestWriteFailure(t *testing.T) {
+	tests := []struct {
+		err       error
+		heartbeat bool
+		reason    DisconnectReason
+	}{
+		{errors.New("broken pipe"), false, WriteError},
+		{os.ErrDeadlineExceeded, false, WriteTimeout},
+		{errors.New("broken pipe"), true, HeartbeatFailure},
+	}
+	for _, test := range tests {
+		result := writeFailure(test.err, test.heartbeat)
+		if result != test.reason {
+			t.Errorf("expected:\n%s\ngot:\n%s\n", test.reason, result)
+		}
+	}
+}
+
+func TestServerHooks(t *testing.T) {
+	type hook struct {
+		id     string
+		reason DisconnectReason
+		joined bool
+	}
+	hooks := make(chan hook, 4)
+	s := server{
+		add:        make(chan client),
+		remove:     make(chan client),
+		kicks:      make(chan kick),
+		dispatcher: newDispatcher(),
+		onConnect: func(c Client) {
+			hooks <- hook{id: c.ID(), joined: true}
+		},
+		onDisconnect: func(c Client, reason DisconnectReason) {
+			hooks <- hook{id: c.ID(), reason: reason}
+		},
+	}
+	_, w1 := net.Pipe()
+	_, w2 := net.Pipe()
+	c1 := client{id: "a", events: make(chan payload, 1), done: make(chan bool), quit: make(chan []byte, 1), conn: w1}
+	c2 := client{id: "b", events: make(chan payload, 1), done: make(chan bool), conn: w2}
+	go s.listen()
+	s.add <- c1
+	s.add <- c2
+	s.kicks <- kick{selector: ByID("a")}
+	c2.reason = WriteError
+	s.remove <- c2
+
+	expecting := []hook{
+		{id: "a", joined: true},
+		{id: "b", joined: true},
+		{id: "a", reason: Kicked},
+		{id: "b", reason: WriteError},
+	}
+	var result []hook
+	for range expecting {
+		select {
+		case h := <-hooks:
+			result = append(result, h)
+		case <-time.After(1 * time.Second):
+			t.Fatalf("expected hooks to be called\ngot:\n%v\n", result)
+		}
+	}
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\ngot:\n%v\n", expecting, result)
+	}
+}
+
+func TestEventsourceHooks(t *testing.T) {
+	connected := make(chan Client, 1)
+	disconnected := make(chan DisconnectReason, 1)
+	es := &Eventsource{
+		Metrics:           NoopMetrics{},
+		ChannelSubscriber: QueryStringChannels{Name: "channels"},
+		OnConnect: func(c Client) {
+			connected <- c
+		},
+		OnDisconnect: func(c Client, reason DisconnectReason) {
+			disconnected <- reason
+		},
+	}
+	es.Start()
+	server := httptest.NewServer(es)
+	defer server.Close()
+	res, err := http.Get(server.URL + "?channels=a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+	bufio.NewReader(res.Body).ReadBytes('\n')
+
+	c := <-connected
+	if !reflect.DeepEqual([]string{"a"}, c.Channels()) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"a"}, c.Channels())
+	}
+	es.Stop()
+	select {
+	case reason := <-disconnected:
+		if reason != Shutdown {
+			t.Errorf("expected:\n%s\ngot:\n%s\n", Shutdown, reason)
+		}
+	default:
+		t.Errorf("expected OnDisconnect to be called before Stop returns")
+	}
+}
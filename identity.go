@@ -0,0 +1,66 @@
+package eventsource
+
+import "net/http"
+
+// IdentityResolver interface is used to determine which user a client belongs
+// to, so events can be sent to every connection the user has open. This
+// package has two built-in implementations: NoIdentity and HeaderIdentity, but
+// you can implement your own.
+type IdentityResolver interface {
+	Identity(*http.Request) string
+}
+
+// NoIdentity implements the IdentityResolver interface by always returning an
+// empty identity. Clients can only be targeted by their ID.
+type NoIdentity struct{}
+
+// Identity returns an empty identity.
+func (NoIdentity) Identity(*http.Request) string {
+	return ""
+}
+
+// HeaderIdentity implements the IdentityResolver interface by reading the
+// identity from a request header, usually set by an authenticating proxy. Eg.:
+// X-User-ID: 42
+type HeaderIdentity struct {
+	Name string
+}
+
+// Identity returns the value of the Name header.
+func (h HeaderIdentity) Identity(req *http.Request) string {
+	return req.Header.Get(h.Name)
+}
+
+// A direct event is sent only to the client with the id, regardless of the
+// channels of the event it wraps.
+type direct struct {
+	event Event
+	id    string
+}
+
+// Bytes returns the data of the event wrapped.
+func (d direct) Bytes() []byte {
+	return d.event.Bytes()
+}
+
+// Match selects the client with the id.
+func (d direct) Match(c Client) bool {
+	return c.id == d.id
+}
+
+// A personal event is sent to every client with the identity, regardless of
+// the channels of the event it wraps.
+type personal struct {
+	event    Event
+	identity string
+}
+
+// Bytes returns the data of the event wrapped.
+func (p personal) Bytes() []byte {
+	return p.event.Bytes()
+}
+
+// Match selects the clients with the identity.
+func (p personal) Match(c Client) bool {
+	return p.identity != "" && c.identity == p.identity
+}
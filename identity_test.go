@@ -0,0 +1,50 @@
+package eventsource
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestNoIdentity(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	if result := (NoIdentity{}).Identity(req); result != "" {
+		t.Errorf("expected:\n%q\nto be empty\n", result)
+	}
+}
+
+func TestHeaderIdentity(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User-ID", "42")
+	result := HeaderIdentity{Name: "X-User-ID"}.Identity(req)
+	if result != "42" {
+		t.Errorf("expected:\n42\ngot:\n%s\n", result)
+	}
+}
+
+func TestDirectEvent(t *testing.T) {
+	e := DefaultEvent{Message: message, Channels: []string{"a"}}
+	d := direct{event: e, id: "b"}
+	if !bytes.Equal(e.Bytes(), d.Bytes()) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", e.Bytes(), d.Bytes())
+	}
+	if d.Match(Client{id: "a", channels: []string{"a"}}) {
+		t.Errorf("expected client a not to be matched")
+	}
+	if !d.Match(Client{id: "b"}) {
+		t.Errorf("expected client b to be matched")
+	}
+}
+
+func TestPersonalEvent(t *testing.T) {
+	p := personal{event: DefaultEvent{Message: message}, identity: "42"}
+	if !p.Match(Client{identity: "42"}) {
+		t.Errorf("expected client of user 42 to be matched")
+	}
+	if p.Match(Client{identity: "1"}) {
+		t.Errorf("expected client of user 1 not to be matched")
+	}
+	if (personal{}).Match(Client{}) {
+		t.Errorf("expected anonymous clients not to be matched")
+	}
+}
@@ -0,0 +1,129 @@
+package eventsource
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An IDGenerator returns the ids stamped on events sent without one. Ids must
+// be unique and should increase, so browsers resuming with a Last-Event-ID
+// header are found in the EventStore, even after the server restarts. This
+// package has two built-in implementations: CounterIDs and ULIDs, but you can
+// implement your own.
+type IDGenerator interface {
+	NextID() string
+}
+
+// An Identifiable event has an id that can be set when it is sent, see
+// IDGenerator. DefaultEvent is Identifiable. Types embedding DefaultEvent must
+// implement WithID themselves to be stamped, see TypedEvent.
+type Identifiable interface {
+	Event
+
+	// EventID returns the event id, empty if the event has none.
+	EventID() string
+
+	// WithID returns a copy of the event with the id passed in.
+	WithID(id string) Event
+}
+
+// stamp sets an id from the generator on events without one, including the
+// events sent to a client or a user. Events whose WithID returns another type,
+// eg. a type embedding DefaultEvent without its own WithID, are left as they
+// are, so their Render and Match methods aren't lost.
+func stamp(e Event, ids IDGenerator) Event {
+	switch e := e.(type) {
+	case direct:
+		e.event = stamp(e.event, ids)
+		return e
+	case personal:
+		e.event = stamp(e.event, ids)
+		return e
+	case Identifiable:
+		if e.EventID() != "" {
+			return e
+		}
+		stamped := e.WithID(ids.NextID())
+		if reflect.TypeOf(stamped) == reflect.TypeOf(e) {
+			return stamped
+		}
+	}
+	return e
+}
+
+// CounterIDs implements the IDGenerator interface with a counter, prefixed
+// by Prefix. Counters don't survive restarts, unless they start from the last
+// id sent, see NewCounterIDs.
+type CounterIDs struct {
+	Prefix string
+	mu     sync.Mutex
+	last   uint64
+}
+
+// NewCounterIDs returns a CounterIDs whose first id is last plus one.
+func NewCounterIDs(prefix string, last uint64) *CounterIDs {
+	return &CounterIDs{Prefix: prefix, last: last}
+}
+
+// NextID increments the counter, returning its value with the prefix.
+func (c *CounterIDs) NextID() string {
+	c.mu.Lock()
+	c.last++
+	n := c.last
+	c.mu.Unlock()
+	return c.Prefix + strconv.FormatUint(n, 10)
+}
+
+// crockford is the base32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDs implements the IDGenerator interface with Universally Unique
+// Lexicographically Sortable Identifiers: 48 bits of milliseconds since the
+// epoch followed by 80 random bits, encoded in 26 characters. Ids generated in
+// the same millisecond increment the random bits of the previous one, so ids
+// always increase, even across restarts as long as the clock does.
+type ULIDs struct {
+	mu      sync.Mutex
+	ms      uint64
+	entropy [10]byte
+}
+
+// NextID returns a new ULID.
+func (u *ULIDs) NextID() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ms := uint64(time.Now().UnixMilli())
+	if ms > u.ms {
+		u.ms = ms
+		rand.Read(u.entropy[:])
+	} else {
+		for i := len(u.entropy) - 1; i >= 0; i-- {
+			u.entropy[i]++
+			if u.entropy[i] != 0 {
+				break
+			}
+		}
+	}
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], u.ms<<16)
+	copy(id[6:], u.entropy[:])
+	return encodeULID(id)
+}
+
+// encodeULID encodes 128 bits in 26 base32 characters, the first one holding
+// only 3 bits.
+func encodeULID(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
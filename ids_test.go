@@ -0,0 +1,97 @@
+package eventsource
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCounterIDs(t *testing.T) {
+	ids := NewCounterIDs("n1-", 41)
+	if id := ids.NextID(); id != "n1-42" {
+		t.Errorf("expected:\nn1-42\ngot:\n%s\n", id)
+	}
+	if id := ids.NextID(); id != "n1-43" {
+		t.Errorf("expected:\nn1-43\ngot:\n%s\n", id)
+	}
+}
+
+func TestULIDs(t *testing.T) {
+	ids := &ULIDs{}
+	var generated []string
+	for i := 0; i < 1000; i++ {
+		generated = append(generated, ids.NextID())
+	}
+	if !sort.StringsAreSorted(generated) {
+		t.Errorf("expected ids to increase")
+	}
+	seen := make(map[string]bool)
+	for _, id := range generated {
+		if len(id) != 26 {
+			t.Fatalf("expected:\n26 characters\ngot:\n%s\n", id)
+		}
+		if seen[id] {
+			t.Fatalf("expected ids to be unique\ngot:\n%s twice\n", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestEncodeULID(t *testing.T) {
+	var id [16]byte
+	if result := encodeULID(id); result != "00000000000000000000000000" {
+		t.Errorf("expected:\n00000000000000000000000000\ngot:\n%s\n", result)
+	}
+	for i := range id {
+		id[i] = 0xff
+	}
+	if result := encodeULID(id); result != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("expected:\n7ZZZZZZZZZZZZZZZZZZZZZZZZZ\ngot:\n%s\n", result)
+	}
+}
+
+func TestEventsourceIDGenerator(t *testing.T) {
+	es := Eventsource{IDGenerator: NewCounterIDs("", 0)}
+	events := make(chan Event, 4)
+	es.server = server{events: events}
+
+	es.Send(DefaultEvent{Message: message})
+	es.Send(DefaultEvent{ID: "x", Message: message})
+	es.SendTo("c1", DefaultEvent{Message: message})
+	es.Send(CloseEvent{})
+
+	expecting := []Event{
+		DefaultEvent{ID: "1", Message: message},
+		DefaultEvent{ID: "x", Message: message},
+		direct{event: DefaultEvent{ID: "2", Message: message}, id: "c1"},
+		CloseEvent{},
+	}
+	for _, e := range expecting {
+		if result := <-events; !reflect.DeepEqual(e, result) {
+			t.Errorf("expected:\n%v\ngot:\n%v\n", e, result)
+		}
+	}
+}
+
+func TestEventsourceIDGeneratorRenderer(t *testing.T) {
+	es := Eventsource{IDGenerator: NewCounterIDs("", 0)}
+	events := make(chan Event, 1)
+	es.server = server{events: events}
+
+	renders := 0
+	es.Send(handEvent{
+		DefaultEvent: DefaultEvent{Message: []byte("hidden")},
+		hands:        map[string]string{"alice": "AK"},
+		renders:      &renders,
+	})
+	e, ok := (<-events).(handEvent)
+	if !ok {
+		t.Fatalf("expected event to keep its type\ngot:\n%T\n", e)
+	}
+	c := client{id: "1", identity: "alice", events: make(chan payload, 1)}
+	es.server.send(e, poolOf(c))
+	expecting := Fields{Event: "deal", Data: []byte("AK")}.Bytes()
+	if result := (<-c.events).bytes(); !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, result)
+	}
+}
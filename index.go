@@ -0,0 +1,304 @@
+package eventsource
+
+import "strings"
+
+// A routed event is sent to the clients subscribed to its channels, or to all
+// clients if it has no channels. The server uses these channels to find the
+// clients through the pool index instead of calling Match for every client.
+type routed interface {
+	channels() []string
+}
+
+// route returns the channels of routed events, including the events embedding
+// a built-in routed event, such as renderers.
+func route(e Event) ([]string, bool) {
+	if r, ok := e.(routed); ok {
+		return r.channels(), true
+	}
+	return nil, false
+}
+
+// exact returns true if the event is a built-in routed event, whose Match
+// selects exactly the subscribers of its channels. Events embedding one can
+// override Match, so the subscribers found through the index are still
+// matched by Match: an override can narrow the clients selected but not add
+// clients outside the event channels.
+func exact(e Event) bool {
+	switch e.(type) {
+	case DefaultEvent, Record, ping, JSONEvent, CommentEvent:
+		return true
+	}
+	return false
+}
+
+// target returns the client id or identity of events sent to specific
+// clients, which the pool finds through its index.
+func target(e Event) (id string, identity string, ok bool) {
+	switch e := e.(type) {
+	case direct:
+		return e.id, "", true
+	case personal:
+		return "", e.identity, true
+	}
+	return "", "", false
+}
+
+// A pool holds the connected clients, indexing them by the channels they have
+// subscribed to. Clients are identified by their events channel. Exact
+// channels are kept in a map while wildcard subscriptions are kept in a tree
+// of channel tokens, so finding the subscribers of a channel costs
+// proportionally to the number of subscribers and not to the number of
+// clients. Clients are also indexed by id and identity. A pool is only
+// accessed by the server listen loop and it is not safe for concurrent use.
+type pool struct {
+	clients   []client
+	positions map[chan payload]int
+	exact     map[string]map[chan payload]bool
+	wildcards *node
+	ids       map[string]chan payload
+	users     map[string]map[chan payload]bool
+}
+
+// A node is a token of wildcard subscriptions. Clients whose subscription ends
+// on the node are kept in subs.
+type node struct {
+	children map[string]*node
+	subs     map[chan payload]bool
+}
+
+func newPool() *pool {
+	return &pool{
+		positions: make(map[chan payload]int),
+		exact:     make(map[string]map[chan payload]bool),
+		wildcards: &node{},
+		ids:       make(map[string]chan payload),
+		users:     make(map[string]map[chan payload]bool),
+	}
+}
+
+// has returns true if the client is in the pool.
+func (p *pool) has(c client) bool {
+	_, ok := p.positions[c.events]
+	return ok
+}
+
+// add appends a client to the pool, indexing its channels.
+func (p *pool) add(c client) {
+	p.positions[c.events] = len(p.clients)
+	p.clients = append(p.clients, c)
+	p.index(c.events, c.channels)
+	if c.id != "" {
+		p.ids[c.id] = c.events
+	}
+	if c.identity != "" {
+		if p.users[c.identity] == nil {
+			p.users[c.identity] = make(map[chan payload]bool)
+		}
+		p.users[c.identity][c.events] = true
+	}
+}
+
+// remove takes a client out of the pool by moving the last client to its
+// position. It returns the client as it was in the pool, with its current
+// channels, or false if the client is not in the pool.
+func (p *pool) remove(c client) (client, bool) {
+	i, ok := p.positions[c.events]
+	if !ok {
+		return c, false
+	}
+	c = p.clients[i]
+	p.unindex(c.events, c.channels)
+	delete(p.positions, c.events)
+	if p.ids[c.id] == c.events {
+		delete(p.ids, c.id)
+	}
+	delete(p.users[c.identity], c.events)
+	if len(p.users[c.identity]) == 0 {
+		delete(p.users, c.identity)
+	}
+
+	last := len(p.clients) - 1
+	if i < last {
+		swap := p.clients[last]
+		p.clients[i] = swap
+		p.positions[swap.events] = i
+	}
+	p.clients[last] = client{}
+	p.clients = p.clients[:last]
+	return c, true
+}
+
+// update replaces the channels of the client at position i, updating the
+// index.
+func (p *pool) update(i int, channels []string) {
+	c := p.clients[i]
+	p.unindex(c.events, c.channels)
+	p.clients[i].channels = channels
+	p.index(c.events, channels)
+}
+
+// match returns the clients an event must be sent to. Routed and targeted
+// events use the index, any other event is matched against every client.
+// Routed events that aren't built-in are also matched against the clients
+// found, see exact.
+func (p *pool) match(e Event) []client {
+	if id, identity, ok := target(e); ok {
+		var selected []client
+		if key, ok := p.ids[id]; ok {
+			selected = append(selected, p.clients[p.positions[key]])
+		}
+		for key := range p.users[identity] {
+			selected = append(selected, p.clients[p.positions[key]])
+		}
+		return selected
+	}
+	channels, ok := route(e)
+	if !ok {
+		return targets(e, p.clients)
+	}
+	selected := p.subscribers(channels)
+	if !exact(e) {
+		return targets(e, selected)
+	}
+	return selected
+}
+
+// subscribers returns the clients subscribed to at least one of the channels,
+// or every client if there are no channels.
+func (p *pool) subscribers(channels []string) []client {
+	if len(channels) == 0 {
+		selected := make([]client, len(p.clients))
+		copy(selected, p.clients)
+		return selected
+	}
+	var selected []client
+	seen := make(map[chan payload]bool)
+	found := func(key chan payload) {
+		if !seen[key] {
+			seen[key] = true
+			selected = append(selected, p.clients[p.positions[key]])
+		}
+	}
+	for _, name := range channels {
+		for key := range p.exact[name] {
+			found(key)
+		}
+		if name != "" {
+			p.wildcards.match(strings.Split(name, "."), found)
+		}
+	}
+	return selected
+}
+
+// index adds a client key to the subscribers of each channel.
+func (p *pool) index(key chan payload, channels []string) {
+	for _, name := range channels {
+		tokens, wildcard := parsePattern(name)
+		if !wildcard {
+			subs := p.exact[name]
+			if subs == nil {
+				subs = make(map[chan payload]bool)
+				p.exact[name] = subs
+			}
+			subs[key] = true
+		} else if tokens != nil {
+			p.wildcards.add(tokens, key)
+		}
+	}
+}
+
+// unindex removes a client key from the subscribers of each channel.
+func (p *pool) unindex(key chan payload, channels []string) {
+	for _, name := range channels {
+		tokens, wildcard := parsePattern(name)
+		if !wildcard {
+			delete(p.exact[name], key)
+			if len(p.exact[name]) == 0 {
+				delete(p.exact, name)
+			}
+		} else if tokens != nil {
+			p.wildcards.remove(tokens, key)
+		}
+	}
+}
+
+// parsePattern splits a channel in tokens and reports whether it is a wildcard
+// subscription. Wildcard subscriptions that can never match, with a ">" token
+// that is not the last one, return no tokens.
+func parsePattern(channel string) ([]string, bool) {
+	if !strings.ContainsAny(channel, "*>") {
+		return nil, false
+	}
+	tokens := strings.Split(channel, ".")
+	wildcard := false
+	for i, t := range tokens {
+		if t == ">" && i < len(tokens)-1 {
+			return nil, true
+		}
+		if t == "*" || t == ">" {
+			wildcard = true
+		}
+	}
+	if !wildcard {
+		return nil, false
+	}
+	return tokens, true
+}
+
+// add stores a client key at the end of the tokens path.
+func (n *node) add(tokens []string, key chan payload) {
+	for _, t := range tokens {
+		if n.children == nil {
+			n.children = make(map[string]*node)
+		}
+		child := n.children[t]
+		if child == nil {
+			child = &node{}
+			n.children[t] = child
+		}
+		n = child
+	}
+	if n.subs == nil {
+		n.subs = make(map[chan payload]bool)
+	}
+	n.subs[key] = true
+}
+
+// remove deletes a client key at the end of the tokens path, pruning nodes
+// left empty. It returns true if the node itself is empty.
+func (n *node) remove(tokens []string, key chan payload) bool {
+	if len(tokens) == 0 {
+		delete(n.subs, key)
+	} else if child := n.children[tokens[0]]; child != nil {
+		if child.remove(tokens[1:], key) {
+			delete(n.children, tokens[0])
+		}
+	}
+	return len(n.subs) == 0 && len(n.children) == 0
+}
+
+// match calls found for every client key with a subscription matching the
+// channel tokens. Like MatchChannel, an empty last token, as in "a.", is
+// matched by no subscription.
+func (n *node) match(tokens []string, found func(chan payload)) {
+	if len(tokens) == 0 {
+		for key := range n.subs {
+			found(key)
+		}
+		return
+	}
+	if len(tokens) == 1 && tokens[0] == "" {
+		return
+	}
+	if child := n.children[tokens[0]]; child != nil {
+		child.match(tokens[1:], found)
+	}
+	if child := n.children["*"]; child != nil {
+		child.match(tokens[1:], found)
+	}
+	if child := n.children[">"]; child != nil {
+		for key := range child.subs {
+			found(key)
+		}
+	}
+}
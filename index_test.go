@@ -0,0 +1,231 @@
+package eventsource
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func poolOf(clients ...client) *pool {
+	p := newPool()
+	for _, c := range clients {
+		p.add(c)
+	}
+	return p
+}
+
+func matchedIDs(clients []client) []string {
+	ids := []string{}
+	for _, c := range clients {
+		ids = append(ids, c.id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestPoolMatch(t *testing.T) {
+	p := poolOf(
+		client{id: "a", channels: []string{"table.1"}, events: make(chan payload)},
+		client{id: "b", channels: []string{"table.*"}, events: make(chan payload)},
+		client{id: "c", channels: []string{"table.>", "lobby"}, events: make(chan payload)},
+		client{id: "d", channels: []string{"lobby"}, events: make(chan payload)},
+		client{id: "e", events: make(chan payload)},
+	)
+	tests := []struct {
+		channels []string
+		ids      []string
+	}{
+		{nil, []string{"a", "b", "c", "d", "e"}},
+		{[]string{"table.1"}, []string{"a", "b", "c"}},
+		{[]string{"table.2"}, []string{"b", "c"}},
+		{[]string{"table.1.chat"}, []string{"c"}},
+		{[]string{"lobby"}, []string{"c", "d"}},
+		{[]string{"table.1", "lobby"}, []string{"a", "b", "c", "d"}},
+		{[]string{"table"}, []string{}},
+	}
+	for _, test := range tests {
+		result := matchedIDs(p.match(DefaultEvent{Channels: test.channels}))
+		if !reflect.DeepEqual(test.ids, result) {
+			t.Errorf("expected %q to match:\n%q\ngot:\n%q\n", test.channels, test.ids, result)
+		}
+	}
+}
+
+func TestPoolMatchAgreesWithScan(t *testing.T) {
+	patterns := []string{"a", "a.b", "a.*", "a.>", "*.b", ">", "a.>.b", "a*", "*", "a.*.c", "a.*.", "*.*"}
+	channels := []string{"a", "a.b", "a.c", "b.b", "a.b.c", "a*", "a..c", "a.", ".a", "a.b.", ".", "a.."}
+	var clients []client
+	for i, pattern := range patterns {
+		clients = append(clients, client{
+			id:       fmt.Sprint(i),
+			channels: []string{pattern},
+			events:   make(chan payload),
+		})
+	}
+	p := poolOf(clients...)
+	for _, channel := range channels {
+		e := DefaultEvent{Channels: []string{channel}}
+		expecting := matchedIDs(targets(e, clients))
+		result := matchedIDs(p.match(e))
+		if !reflect.DeepEqual(expecting, result) {
+			t.Errorf("expected %q to match:\n%q\ngot:\n%q\n", channel, expecting, result)
+		}
+	}
+}
+
+func TestPoolMatchCustomEvent(t *testing.T) {
+	p := poolOf(
+		client{id: "a", events: make(chan payload)},
+		client{id: "b", events: make(chan payload)},
+	)
+	result := matchedIDs(p.match(idEvent{id: "b"}))
+	if !reflect.DeepEqual([]string{"b"}, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"b"}, result)
+	}
+}
+
+func TestPoolRemove(t *testing.T) {
+	c1 := client{id: "a", channels: []string{"x", "y.*"}, events: make(chan payload)}
+	c2 := client{id: "b", channels: []string{"x"}, events: make(chan payload)}
+	p := poolOf(c1, c2)
+	p.remove(c1)
+
+	result := matchedIDs(p.match(DefaultEvent{Channels: []string{"x", "y.1"}}))
+	if !reflect.DeepEqual([]string{"b"}, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"b"}, result)
+	}
+	if len(p.wildcards.children) > 0 {
+		t.Errorf("expected wildcard tree to be pruned\ngot:\n%v\n", p.wildcards.children)
+	}
+	if p.has(c1) {
+		t.Errorf("expected client to be removed")
+	}
+}
+
+func TestPoolUpdate(t *testing.T) {
+	c := client{id: "a", channels: []string{"x"}, events: make(chan payload)}
+	p := poolOf(c)
+	p.update(0, []string{"y.>"})
+
+	if result := p.match(DefaultEvent{Channels: []string{"x"}}); len(result) > 0 {
+		t.Errorf("expected:\n%v\nto be empty\n", result)
+	}
+	result := matchedIDs(p.match(DefaultEvent{Channels: []string{"y.1"}}))
+	if !reflect.DeepEqual([]string{"a"}, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"a"}, result)
+	}
+}
+
+// benchmarkPool creates 10000 clients spread over 1000 channels, each client
+// subscribed to 3 channels.
+func benchmarkPool() *pool {
+	p := newPool()
+	for i := 0; i < 10000; i++ {
+		p.add(client{
+			channels: []string{
+				fmt.Sprintf("table.%d", i%1000),
+				fmt.Sprintf("table.%d", (i+1)%1000),
+				fmt.Sprintf("lobby.%d", i%10),
+			},
+			events: make(chan payload),
+		})
+	}
+	return p
+}
+
+func BenchmarkTargetsScan(b *testing.B) {
+	p := benchmarkPool()
+	e := DefaultEvent{Channels: []string{"table.42"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		targets(e, p.clients)
+	}
+}
+
+func BenchmarkTargetsIndex(b *testing.B) {
+	p := benchmarkPool()
+	e := DefaultEvent{Channels: []string{"table.42"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.match(e)
+	}
+}
+
+func BenchmarkTargetsIndexWildcard(b *testing.B) {
+	p := benchmarkPool()
+	p.add(client{channels: []string{"table.*"}, events: make(chan payload)})
+	e := DefaultEvent{Channels: []string{"table.42"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.match(e)
+	}
+}
+
+func TestPoolMatchTargeted(t *testing.T) {
+	c1 := client{id: "a", identity: "42", events: make(chan payload)}
+	c2 := client{id: "b", identity: "42", events: make(chan payload)}
+	c3 := client{id: "c", identity: "1", events: make(chan payload)}
+	p := poolOf(c1, c2, c3)
+	e := DefaultEvent{Message: message, Channels: []string{"x"}}
+
+	result := matchedIDs(p.match(direct{event: e, id: "c"}))
+	if !reflect.DeepEqual([]string{"c"}, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"c"}, result)
+	}
+	result = matchedIDs(p.match(personal{event: e, identity: "42"}))
+	if !reflect.DeepEqual([]string{"a", "b"}, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"a", "b"}, result)
+	}
+
+	p.remove(c1)
+	result = matchedIDs(p.match(personal{event: e, identity: "42"}))
+	if !reflect.DeepEqual([]string{"b"}, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"b"}, result)
+	}
+	result = matchedIDs(p.match(direct{event: e, id: "a"}))
+	if len(result) > 0 {
+		t.Errorf("expected:\n%q\nto be empty\n", result)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	typed, _ := NewTypedEvent("score", 1, "table.1")
+	tests := []struct {
+		event    Event
+		channels []string
+		routed   bool
+	}{
+		{DefaultEvent{Channels: []string{"a"}}, []string{"a"}, true},
+		{Record{Channels: []string{"a"}}, []string{"a"}, true},
+		{JSONEvent{Channels: []string{"a"}}, []string{"a"}, true},
+		{typed, []string{"table.1"}, true},
+		{CommentEvent{Channels: []string{"a"}}, []string{"a"}, true},
+		{ping{}, nil, true},
+		{idEvent{DefaultEvent{Channels: []string{"a"}}, "b"}, []string{"a"}, true},
+		{CloseEvent{}, nil, false},
+	}
+	for _, test := range tests {
+		channels, ok := route(test.event)
+		if ok != test.routed || !reflect.DeepEqual(test.channels, channels) {
+			t.Errorf("expected %T to route to:\n%q %t\ngot:\n%q %t\n", test.event, test.channels, test.routed, channels, ok)
+		}
+	}
+}
+
+func TestPoolMatchEmbeddedEvent(t *testing.T) {
+	p := poolOf(
+		client{id: "a", channels: []string{"x"}, events: make(chan payload)},
+		client{id: "b", channels: []string{"x"}, events: make(chan payload)},
+		client{id: "c", channels: []string{"y"}, events: make(chan payload)},
+	)
+	result := matchedIDs(p.match(idEvent{DefaultEvent{Channels: []string{"x"}}, "b"}))
+	if !reflect.DeepEqual([]string{"b"}, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"b"}, result)
+	}
+	typed, _ := NewTypedEvent("score", 1, "x")
+	result = matchedIDs(p.match(typed))
+	if !reflect.DeepEqual([]string{"a", "b"}, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"a", "b"}, result)
+	}
+}
@@ -0,0 +1,213 @@
+package eventsource
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// MissingCredentialsError is displayed when a request has no token.
+	MissingCredentialsError = "missing credentials"
+
+	// InvalidCredentialsError is displayed when a token is malformed, has an
+	// invalid signature, is not valid yet or has expired.
+	InvalidCredentialsError = "invalid credentials"
+
+	// ChannelNotAllowedError is displayed when a client subscribes to a
+	// channel its token doesn't allow.
+	ChannelNotAllowedError = "channel not allowed"
+)
+
+// JWTAuthorizer implements the Authorizer interface by validating a JSON Web
+// Token. Since browsers can't set headers on an EventSource, the token is read
+// from the Authorization header as a bearer token, then from the cookie and
+// finally from the querystring parameter.
+// The algorithm is chosen by the type of the key: HS256 for a []byte secret,
+// RS256 for a *rsa.PublicKey and ES256 for a *ecdsa.PublicKey; tokens signed
+// with any other algorithm are rejected.
+// The principal ID is the identity claim and its expiry is the exp claim, so
+// clients are disconnected when their token expires.
+type JWTAuthorizer struct {
+	Key interface{}
+
+	// Cookie is the name of the cookie holding the token, if any.
+	Cookie string
+
+	// Param is the querystring parameter holding the token. It defaults to
+	// "access_token".
+	Param string
+
+	// IdentityClaim is the claim used as the principal ID. It defaults to
+	// "sub".
+	IdentityClaim string
+
+	// ChannelsClaim is the claim listing the channels, or wildcard
+	// subscriptions, the client can subscribe to. It defaults to "channels".
+	// Tokens without the claim can subscribe to any channel, while tokens
+	// whose claim is not a list of strings are invalid.
+	ChannelsClaim string
+
+	// Leeway is the clock skew tolerated when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// Authorize validates the token of the request and checks that the client is
+// allowed to subscribe to the channels. String claims are kept in the
+// principal attributes.
+func (j JWTAuthorizer) Authorize(req *http.Request, channels []string) (Principal, error) {
+	raw := j.token(req)
+	if raw == "" {
+		return Principal{}, Unauthorized(MissingCredentialsError)
+	}
+	claims, ok := j.verify(raw)
+	if !ok {
+		return Principal{}, Unauthorized(InvalidCredentialsError)
+	}
+
+	now := time.Now()
+	principal := Principal{Attributes: make(map[string]string)}
+	if claim, ok := claims["exp"]; ok {
+		exp, ok := claim.(float64)
+		if !ok {
+			return Principal{}, Unauthorized(InvalidCredentialsError)
+		}
+		principal.Expires = time.Unix(int64(exp), 0).Add(j.Leeway)
+		if !now.Before(principal.Expires) {
+			return Principal{}, Unauthorized(InvalidCredentialsError)
+		}
+	}
+	if claim, ok := claims["nbf"]; ok {
+		nbf, ok := claim.(float64)
+		if !ok || now.Add(j.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return Principal{}, Unauthorized(InvalidCredentialsError)
+		}
+	}
+	for k, v := range claims {
+		if s, ok := v.(string); ok {
+			principal.Attributes[k] = s
+		}
+	}
+	identity := j.IdentityClaim
+	if identity == "" {
+		identity = "sub"
+	}
+	principal.ID = principal.Attributes[identity]
+
+	name := j.ChannelsClaim
+	if name == "" {
+		name = "channels"
+	}
+	if claim, ok := claims[name]; ok {
+		list, ok := claim.([]interface{})
+		if !ok {
+			return Principal{}, Unauthorized(InvalidCredentialsError)
+		}
+		var allowed []string
+		for _, v := range list {
+			s, ok := v.(string)
+			if !ok {
+				return Principal{}, Unauthorized(InvalidCredentialsError)
+			}
+			allowed = append(allowed, s)
+		}
+		for _, c := range channels {
+			if !covers(allowed, c) {
+				return Principal{}, Forbidden(ChannelNotAllowedError)
+			}
+		}
+	}
+	return principal, nil
+}
+
+// token returns the raw token of the request, if any.
+func (j JWTAuthorizer) token(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if j.Cookie != "" {
+		if cookie, err := req.Cookie(j.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	param := j.Param
+	if param == "" {
+		param = "access_token"
+	}
+	return req.URL.Query().Get(param)
+}
+
+// verify checks the token algorithm and signature, returning its claims.
+func (j JWTAuthorizer) verify(raw string) (map[string]interface{}, bool) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if !decodeSegment(parts[0], &header) {
+		return nil, false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, false
+	}
+	signed := parts[0] + "." + parts[1]
+	hash := sha256.Sum256([]byte(signed))
+
+	switch key := j.Key.(type) {
+	case []byte:
+		if header.Alg != "HS256" {
+			return nil, false
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, false
+		}
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, false
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) != nil {
+			return nil, false
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || key.Curve != elliptic.P256() || len(sig) != 64 {
+			return nil, false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, hash[:], r, s) {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	var claims map[string]interface{}
+	if !decodeSegment(parts[1], &claims) {
+		return nil, false
+	}
+	return claims, true
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token.
+func decodeSegment(segment string, v interface{}) bool {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
@@ -0,0 +1,150 @@
+package eventsource
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// signJWT returns a token with the claims signed with the key, using the
+// algorithm of the header.
+func signJWT(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func bearer(token string) *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestJWTAuthorizerAlgorithms(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	claims := map[string]interface{}{"sub": "alice"}
+	tests := []struct {
+		alg  string
+		sign interface{}
+		key  interface{}
+	}{
+		{"HS256", secret, secret},
+		{"RS256", rsaKey, &rsaKey.PublicKey},
+		{"ES256", ecKey, &ecKey.PublicKey},
+	}
+	for _, test := range tests {
+		j := JWTAuthorizer{Key: test.key}
+		principal, err := j.Authorize(bearer(signJWT(t, test.alg, test.sign, claims)), nil)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", test.alg, err)
+		}
+		if principal.ID != "alice" {
+			t.Errorf("expected:\nalice\ngot:\n%s\n", principal.ID)
+		}
+	}
+
+	j := JWTAuthorizer{Key: &rsaKey.PublicKey}
+	_, err := j.Authorize(bearer(signJWT(t, "HS256", secret, claims)), nil)
+	checkStatus(t, err, http.StatusUnauthorized)
+
+	j = JWTAuthorizer{Key: []byte("other")}
+	_, err = j.Authorize(bearer(signJWT(t, "HS256", secret, claims)), nil)
+	checkStatus(t, err, http.StatusUnauthorized)
+
+	p224Key, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	j = JWTAuthorizer{Key: &p224Key.PublicKey}
+	_, err = j.Authorize(bearer(signJWT(t, "ES256", p224Key, claims)), nil)
+	checkStatus(t, err, http.StatusUnauthorized)
+}
+
+func TestJWTAuthorizerClaims(t *testing.T) {
+	secret := []byte("secret")
+	j := JWTAuthorizer{Key: secret, IdentityClaim: "uid"}
+	now := time.Now()
+	tests := []struct {
+		claims   map[string]interface{}
+		channels []string
+		code     int
+	}{
+		{map[string]interface{}{"uid": "alice", "exp": now.Add(time.Minute).Unix()}, nil, 0},
+		{map[string]interface{}{"uid": "alice", "exp": now.Add(-time.Minute).Unix()}, nil, http.StatusUnauthorized},
+		{map[string]interface{}{"uid": "alice", "nbf": now.Add(time.Minute).Unix()}, nil, http.StatusUnauthorized},
+		{map[string]interface{}{"uid": "alice", "exp": "1"}, nil, http.StatusUnauthorized},
+		{map[string]interface{}{"uid": "alice", "exp": nil}, nil, http.StatusUnauthorized},
+		{map[string]interface{}{"uid": "alice", "nbf": "1"}, nil, http.StatusUnauthorized},
+		{map[string]interface{}{"uid": "alice", "channels": []string{"table.*"}}, []string{"table.1"}, 0},
+		{map[string]interface{}{"uid": "alice", "channels": []string{"table.*"}}, []string{"lobby"}, http.StatusForbidden},
+		{map[string]interface{}{"uid": "alice"}, []string{"lobby"}, 0},
+		{map[string]interface{}{"uid": "alice", "channels": "lobby"}, []string{"private-admin"}, http.StatusUnauthorized},
+		{map[string]interface{}{"uid": "alice", "channels": nil}, []string{">"}, http.StatusUnauthorized},
+		{map[string]interface{}{"uid": "alice", "channels": []interface{}{"lobby", 1}}, []string{"lobby"}, http.StatusUnauthorized},
+		{map[string]interface{}{"uid": "alice", "channels": []string{"table.*"}}, []string{"table.>"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		principal, err := j.Authorize(bearer(signJWT(t, "HS256", secret, test.claims)), test.channels)
+		if test.code != 0 {
+			checkStatus(t, err, test.code)
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %v: %s", test.claims, err)
+			continue
+		}
+		if principal.ID != "alice" {
+			t.Errorf("expected:\nalice\ngot:\n%s\n", principal.ID)
+		}
+		if exp, ok := test.claims["exp"]; ok && principal.Expires.Unix() != exp {
+			t.Errorf("expected:\n%d\ngot:\n%d\n", exp, principal.Expires.Unix())
+		}
+	}
+}
+
+func TestJWTAuthorizerTokenSources(t *testing.T) {
+	secret := []byte("secret")
+	token := signJWT(t, "HS256", secret, map[string]interface{}{"sub": "alice"})
+	j := JWTAuthorizer{Key: secret, Cookie: "session", Param: "jwt"}
+
+	cookie, _ := http.NewRequest("GET", "/", nil)
+	cookie.AddCookie(&http.Cookie{Name: "session", Value: token})
+	query, _ := http.NewRequest("GET", "/?jwt="+token, nil)
+	missing, _ := http.NewRequest("GET", "/", nil)
+
+	for _, req := range []*http.Request{bearer(token), cookie, query} {
+		if _, err := j.Authorize(req, nil); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	_, err := j.Authorize(missing, nil)
+	checkStatus(t, err, http.StatusUnauthorized)
+}
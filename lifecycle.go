@@ -0,0 +1,144 @@
+package eventsource
+
+import (
+	"sort"
+	"time"
+)
+
+// A lifecycle counts the subscribers of each channel to tell when a channel
+// gains its first subscriber and when it loses its last one. With a grace
+// delay, a channel left without subscribers is only closed if nobody
+// subscribes to it again before the delay passes. A lifecycle is only
+// accessed by the server listen loop; grace timers report back through the
+// timeouts channel. A nil lifecycle tracks nothing.
+type lifecycle struct {
+	grace      time.Duration
+	counts     map[string]int
+	pending    map[string]int
+	generation int
+	timeouts   chan closing
+	stop       chan struct{}
+}
+
+// A closing is a grace timer of a channel left without subscribers. The
+// generation tells apart timers canceled by a new subscriber.
+type closing struct {
+	channel    string
+	generation int
+}
+
+func newLifecycle(grace time.Duration, stop chan struct{}) *lifecycle {
+	return &lifecycle{
+		grace:    grace,
+		counts:   make(map[string]int),
+		pending:  make(map[string]int),
+		timeouts: make(chan closing),
+		stop:     stop,
+	}
+}
+
+// open adds a subscriber to the channels, returning the channels that had no
+// subscribers and were not waiting to be closed.
+func (l *lifecycle) open(channels []string) []string {
+	if l == nil {
+		return nil
+	}
+	var opened []string
+	for _, name := range channels {
+		l.counts[name]++
+		if l.counts[name] > 1 {
+			continue
+		}
+		if _, ok := l.pending[name]; ok {
+			delete(l.pending, name)
+			continue
+		}
+		opened = append(opened, name)
+	}
+	return opened
+}
+
+// vacate removes a subscriber from the channels, returning the channels left
+// without subscribers that must be closed right away. With a grace delay, a
+// timer is started for them instead.
+func (l *lifecycle) vacate(channels []string) []string {
+	if l == nil {
+		return nil
+	}
+	var closed []string
+	for _, name := range channels {
+		if l.counts[name] == 0 {
+			continue
+		}
+		l.counts[name]--
+		if l.counts[name] > 0 {
+			continue
+		}
+		delete(l.counts, name)
+		if l.grace <= 0 {
+			closed = append(closed, name)
+			continue
+		}
+		l.generation++
+		c := closing{channel: name, generation: l.generation}
+		l.pending[name] = c.generation
+		time.AfterFunc(l.grace, func() {
+			select {
+			case l.timeouts <- c:
+			case <-l.stop:
+			}
+		})
+	}
+	return closed
+}
+
+// expire returns true if the grace timer wasn't canceled and the channel must
+// be closed.
+func (l *lifecycle) expire(c closing) bool {
+	if generation, ok := l.pending[c.channel]; !ok || generation != c.generation {
+		return false
+	}
+	delete(l.pending, c.channel)
+	return true
+}
+
+// all returns the sorted channels still open, including the ones waiting to be
+// closed, forgetting them.
+func (l *lifecycle) all() []string {
+	if l == nil {
+		return nil
+	}
+	var channels []string
+	for name := range l.counts {
+		channels = append(channels, name)
+	}
+	for name := range l.pending {
+		channels = append(channels, name)
+	}
+	sort.Strings(channels)
+	l.counts = make(map[string]int)
+	l.pending = make(map[string]int)
+	return channels
+}
+
+// opened runs the OnChannelOpen hook for each channel.
+func (s server) opened(channels []string) {
+	if s.onChannelOpen == nil {
+		return
+	}
+	for _, name := range channels {
+		name := name
+		s.dispatcher.dispatch(func() { s.onChannelOpen(name) })
+	}
+}
+
+// closed runs the OnChannelClose hook for each channel.
+func (s server) closed(channels []string) {
+	if s.onChannelClose == nil {
+		return
+	}
+	for _, name := range channels {
+		name := name
+		s.dispatcher.dispatch(func() { s.onChannelClose(name) })
+	}
+}
@@ -0,0 +1,99 @@
+package eventsource
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLifecycleOpenVacate(t *testing.T) {
+	l := newLifecycle(0, make(chan struct{}))
+	opened := l.open([]string{"a", "b"})
+	if !reflect.DeepEqual([]string{"a", "b"}, opened) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"a", "b"}, opened)
+	}
+	if opened := l.open([]string{"a"}); len(opened) > 0 {
+		t.Errorf("expected channel to be open already\ngot:\n%q\n", opened)
+	}
+	if closed := l.vacate([]string{"a"}); len(closed) > 0 {
+		t.Errorf("expected channel with subscribers to stay open\ngot:\n%q\n", closed)
+	}
+	closed := l.vacate([]string{"a", "b", "c"})
+	if !reflect.DeepEqual([]string{"a", "b"}, closed) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"a", "b"}, closed)
+	}
+}
+
+func TestLifecycleGrace(t *testing.T) {
+	l := newLifecycle(10*time.Millisecond, make(chan struct{}))
+	l.open([]string{"a"})
+	if closed := l.vacate([]string{"a"}); len(closed) > 0 {
+		t.Errorf("expected channel to wait for the grace delay\ngot:\n%q\n", closed)
+	}
+	canceled := <-l.timeouts
+	l.open([]string{"a"})
+	if opened := l.open([]string{"b"}); len(opened) != 1 {
+		t.Errorf("expected:\n[b]\ngot:\n%q\n", opened)
+	}
+	l.vacate([]string{"a"})
+	if l.expire(canceled) {
+		t.Errorf("expected canceled timer not to close the channel")
+	}
+	c := <-l.timeouts
+	if !l.expire(c) {
+		t.Errorf("expected channel to be closed after the grace delay")
+	}
+	if opened := l.open([]string{"a"}); len(opened) != 1 {
+		t.Errorf("expected closed channel to be opened again\ngot:\n%q\n", opened)
+	}
+}
+
+func TestLifecycleAll(t *testing.T) {
+	l := newLifecycle(time.Hour, make(chan struct{}))
+	l.open([]string{"b", "a"})
+	l.vacate([]string{"a"})
+	if !reflect.DeepEqual([]string{"a", "b"}, l.all()) {
+		t.Errorf("expected open and pending channels")
+	}
+	if len(l.all()) > 0 {
+		t.Errorf("expected channels to be forgotten")
+	}
+}
+
+func TestEventsourceChannelHooks(t *testing.T) {
+	hooks := make(chan string, 2)
+	es := &Eventsource{
+		Metrics:           NoopMetrics{},
+		ChannelSubscriber: QueryStringChannels{Name: "channels"},
+		OnChannelOpen: func(channel string) {
+			hooks <- "open " + channel
+		},
+		OnChannelClose: func(channel string) {
+			hooks <- "close " + channel
+		},
+	}
+	es.Start()
+	server := httptest.NewServer(es)
+	defer server.Close()
+	res, err := http.Get(server.URL + "?channels=a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+	bufio.NewReader(res.Body).ReadBytes('\n')
+	if hook := <-hooks; hook != "open a" {
+		t.Errorf("expected:\nopen a\ngot:\n%s\n", hook)
+	}
+	es.Stop()
+	select {
+	case hook := <-hooks:
+		if hook != "close a" {
+			t.Errorf("expected:\nclose a\ngot:\n%s\n", hook)
+		}
+	default:
+		t.Errorf("expected OnChannelClose to be called before Stop returns")
+	}
+}
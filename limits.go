@@ -0,0 +1,294 @@
+package eventsource
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// A StatusError rejects a connection before it starts streaming, answering
+// the http request with the status code and message.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e StatusError) Error() string {
+	return e.Message
+}
+
+// Limits caps how many clients an eventsource accepts. Connections over a
+// limit are rejected before the connection is hijacked. Zero values mean no
+// limit.
+type Limits struct {
+	// MaxClients is the total number of clients.
+	MaxClients int
+
+	// MaxClientsPerIP is the number of clients from the same remote address.
+	MaxClientsPerIP int
+
+	// TrustedProxies are the addresses of proxies whose X-Forwarded-For
+	// header is used to find the remote address of the browser.
+	TrustedProxies []netip.Prefix
+
+	// MaxClientsPerIdentity is the number of clients of the same user, see
+	// IdentityResolver. Anonymous clients are not limited.
+	MaxClientsPerIdentity int
+
+	// EvictOldest makes a user over MaxClientsPerIdentity disconnect its
+	// oldest client instead of being rejected. The evicted client counts
+	// towards the limits until it is disconnected.
+	EvictOldest bool
+
+	// MaxChannelsPerClient is the number of channels a client can subscribe
+	// to, when connecting or with Eventsource.Subscribe.
+	MaxChannelsPerClient int
+
+	// MaxSubscribersPerChannel is the number of clients subscribed to the
+	// same channel, when connecting or with Eventsource.Subscribe. Wildcard
+	// subscriptions are counted as channels of their own: subscribers of
+	// "table.*" count towards the cap of "table.*", not of each channel it
+	// matches, so capping "table.1" doesn't cap the clients receiving its
+	// events through a wildcard.
+	MaxSubscribersPerChannel int
+}
+
+var (
+	// TooManyClientsError is displayed when the eventsource is full.
+	TooManyClientsError = "too many clients"
+
+	// TooManyConnectionsError is displayed when the remote address or the
+	// user has too many clients.
+	TooManyConnectionsError = "too many connections"
+
+	// TooManyChannelsError is displayed when a client subscribes to too many
+	// channels.
+	TooManyChannelsError = "too many channels"
+
+	// ChannelFullError is displayed when a channel has too many subscribers.
+	ChannelFullError = "channel is full"
+)
+
+// A ticket is what the limiter knows about an admitted client.
+type ticket struct {
+	id       string
+	ip       string
+	identity string
+	channels []string
+}
+
+// A limiter counts admitted clients to enforce the limits. Clients are
+// admitted from ServeHTTP and released by the server when they are removed,
+// so a limiter is safe for concurrent use. A nil limiter admits every client.
+type limiter struct {
+	Limits
+	mu       sync.Mutex
+	tickets  map[string]ticket
+	ips      map[string]int
+	users    map[string][]string
+	channels map[string]int
+	evicting map[string]bool
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		Limits:   limits,
+		tickets:  make(map[string]ticket),
+		ips:      make(map[string]int),
+		users:    make(map[string][]string),
+		channels: make(map[string]int),
+		evicting: make(map[string]bool),
+	}
+}
+
+// admit checks the limits for a new client, counting it if it is accepted. It
+// returns the id of a client of the same user that must be evicted to make
+// room, if any, or a StatusError if the client is rejected. The evicted
+// client is counted until the server releases it.
+func (l *limiter) admit(t ticket) (string, error) {
+	if l == nil {
+		return "", nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.MaxClients > 0 && len(l.tickets) >= l.MaxClients {
+		return "", StatusError{http.StatusServiceUnavailable, TooManyClientsError}
+	}
+	if l.MaxClientsPerIP > 0 && l.ips[t.ip] >= l.MaxClientsPerIP {
+		return "", StatusError{http.StatusTooManyRequests, TooManyConnectionsError}
+	}
+	if err := l.fits(nil, t.channels); err != nil {
+		return "", err
+	}
+	var evicted string
+	if t.identity != "" && l.MaxClientsPerIdentity > 0 {
+		var active []string
+		for _, id := range l.users[t.identity] {
+			if !l.evicting[id] {
+				active = append(active, id)
+			}
+		}
+		if len(active) >= l.MaxClientsPerIdentity {
+			if !l.EvictOldest {
+				return "", StatusError{http.StatusTooManyRequests, TooManyConnectionsError}
+			}
+			evicted = active[0]
+			l.evicting[evicted] = true
+		}
+	}
+
+	l.tickets[t.id] = t
+	l.ips[t.ip]++
+	if t.identity != "" {
+		l.users[t.identity] = append(l.users[t.identity], t.id)
+	}
+	for _, name := range t.channels {
+		l.channels[name]++
+	}
+	return evicted, nil
+}
+
+// fits checks the channel limits for a client subscribed to the current
+// channels subscribing to the channels passed in instead.
+func (l *limiter) fits(current, channels []string) error {
+	if l.MaxChannelsPerClient > 0 && len(channels) > l.MaxChannelsPerClient {
+		return StatusError{http.StatusBadRequest, TooManyChannelsError}
+	}
+	if l.MaxSubscribersPerChannel > 0 {
+		for _, name := range difference(channels, current) {
+			if l.channels[name] >= l.MaxSubscribersPerChannel {
+				return StatusError{http.StatusServiceUnavailable, ChannelFullError}
+			}
+		}
+	}
+	return nil
+}
+
+// evicted returns true if the client with the id must be disconnected to make
+// room for a newer client of the same user.
+func (l *limiter) evicted(id string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.evicting[id]
+}
+
+// release stops counting the client with the id. Releasing a client twice has
+// no effect.
+func (l *limiter) release(id string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(id)
+}
+
+func (l *limiter) releaseLocked(id string) {
+	t, ok := l.tickets[id]
+	if !ok {
+		return
+	}
+	delete(l.tickets, id)
+	delete(l.evicting, id)
+	l.ips[t.ip]--
+	if l.ips[t.ip] <= 0 {
+		delete(l.ips, t.ip)
+	}
+	if t.identity != "" {
+		ids := l.users[t.identity]
+		for i, other := range ids {
+			if other == id {
+				ids = append(ids[:i:i], ids[i+1:]...)
+				break
+			}
+		}
+		if len(ids) == 0 {
+			delete(l.users, t.identity)
+		} else {
+			l.users[t.identity] = ids
+		}
+	}
+	l.count(t.channels, -1)
+}
+
+// resubscribe updates the channels counted for a client subscribing or
+// unsubscribing at runtime. It returns a StatusError, without updating the
+// channels, if the client would go over the channel limits.
+func (l *limiter) resubscribe(id string, channels []string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.tickets[id]
+	if !ok {
+		return nil
+	}
+	if err := l.fits(t.channels, channels); err != nil {
+		return err
+	}
+	l.count(t.channels, -1)
+	l.count(channels, 1)
+	t.channels = channels
+	l.tickets[id] = t
+	return nil
+}
+
+// count adds n to the subscribers of each channel.
+func (l *limiter) count(channels []string, n int) {
+	for _, name := range channels {
+		l.channels[name] += n
+		if l.channels[name] <= 0 {
+			delete(l.channels, name)
+		}
+	}
+}
+
+// remoteIP returns the address of the browser. When the request comes from a
+// trusted proxy, the X-Forwarded-For header is read from right to left, and
+// the first address that is not a trusted proxy is used.
+func (l *limiter) remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !l.trusted(host) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+		if !l.trusted(ip) {
+			return ip
+		}
+		host = ip
+	}
+	return host
+}
+
+// trusted returns true if the address belongs to a trusted proxy.
+func (l *limiter) trusted(ip string) bool {
+	if l == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range l.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
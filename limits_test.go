@@ -0,0 +1,181 @@
+package eventsource
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func checkStatus(t *testing.T, err error, code int) {
+	t.Helper()
+	e, ok := err.(StatusError)
+	if !ok {
+		t.Fatalf("expected:\nstatus error %d\ngot:\n%v\n", code, err)
+	}
+	if e.Code != code {
+		t.Errorf("expected:\n%d\ngot:\n%d\n", code, e.Code)
+	}
+}
+
+func TestLimiterMaxClients(t *testing.T) {
+	l := newLimiter(Limits{MaxClients: 1})
+	if _, err := l.admit(ticket{id: "a", ip: "1.1.1.1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, err := l.admit(ticket{id: "b", ip: "2.2.2.2"})
+	checkStatus(t, err, http.StatusServiceUnavailable)
+
+	l.release("a")
+	if _, err := l.admit(ticket{id: "b", ip: "2.2.2.2"}); err != nil {
+		t.Errorf("expected client to be admitted after release\ngot:\n%s\n", err)
+	}
+}
+
+func TestLimiterMaxClientsPerIP(t *testing.T) {
+	l := newLimiter(Limits{MaxClientsPerIP: 1})
+	l.admit(ticket{id: "a", ip: "1.1.1.1"})
+	_, err := l.admit(ticket{id: "b", ip: "1.1.1.1"})
+	checkStatus(t, err, http.StatusTooManyRequests)
+	if _, err := l.admit(ticket{id: "c", ip: "2.2.2.2"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestLimiterMaxClientsPerIdentity(t *testing.T) {
+	l := newLimiter(Limits{MaxClientsPerIdentity: 1})
+	l.admit(ticket{id: "a", identity: "alice"})
+	_, err := l.admit(ticket{id: "b", identity: "alice"})
+	checkStatus(t, err, http.StatusTooManyRequests)
+
+	for _, id := range []string{"c", "d"} {
+		if _, err := l.admit(ticket{id: id}); err != nil {
+			t.Errorf("expected anonymous clients not to be limited\ngot:\n%s\n", err)
+		}
+	}
+}
+
+func TestLimiterEvictOldest(t *testing.T) {
+	l := newLimiter(Limits{MaxClientsPerIdentity: 2, EvictOldest: true})
+	l.admit(ticket{id: "a", identity: "alice"})
+	l.admit(ticket{id: "b", identity: "alice"})
+	evicted, err := l.admit(ticket{id: "c", identity: "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if evicted != "a" {
+		t.Errorf("expected:\na\ngot:\n%s\n", evicted)
+	}
+	if !l.evicted("a") || len(l.tickets) != 3 {
+		t.Errorf("expected evicted client to be counted until it is released")
+	}
+	if evicted, _ := l.admit(ticket{id: "d", identity: "alice"}); evicted != "b" {
+		t.Errorf("expected:\nb\ngot:\n%s\n", evicted)
+	}
+	l.release("a")
+	l.release("b")
+	if !reflect.DeepEqual([]string{"c", "d"}, l.users["alice"]) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"c", "d"}, l.users["alice"])
+	}
+	if l.evicted("a") {
+		t.Errorf("expected released client to be forgotten")
+	}
+}
+
+func TestLimiterChannels(t *testing.T) {
+	l := newLimiter(Limits{MaxChannelsPerClient: 2, MaxSubscribersPerChannel: 1})
+	_, err := l.admit(ticket{id: "a", channels: []string{"x", "y", "z"}})
+	checkStatus(t, err, http.StatusBadRequest)
+
+	l.admit(ticket{id: "b", channels: []string{"x"}})
+	_, err = l.admit(ticket{id: "c", channels: []string{"y", "x"}})
+	checkStatus(t, err, http.StatusServiceUnavailable)
+
+	if err := l.resubscribe("b", []string{"x", "y", "z"}); err == nil {
+		t.Errorf("expected resubscribe over the channels limit to fail")
+	}
+	l.resubscribe("b", []string{"y"})
+	if _, err := l.admit(ticket{id: "c", channels: []string{"x"}}); err != nil {
+		t.Errorf("expected channel to have room after unsubscribe\ngot:\n%s\n", err)
+	}
+	if l.channels["x"] != 1 {
+		t.Errorf("expected:\n1 subscriber\ngot:\n%d\n", l.channels["x"])
+	}
+}
+
+func TestServerSubscribeLimits(t *testing.T) {
+	s := server{limiter: newLimiter(Limits{MaxSubscribersPerChannel: 1})}
+	c := client{id: "a", channels: []string{"x"}, events: make(chan payload)}
+	s.limiter.admit(ticket{id: "a", channels: c.channels})
+	s.subscribe(poolOf(c), subscription{selector: ByID("a"), channels: []string{"y"}})
+	_, err := s.limiter.admit(ticket{id: "b", channels: []string{"y"}})
+	if err == nil {
+		t.Errorf("expected runtime subscription to count towards the limits")
+	}
+
+	other := client{id: "c", channels: []string{"z"}, events: make(chan payload)}
+	s.limiter.admit(ticket{id: "c", channels: other.channels})
+	clients := poolOf(other)
+	s.subscribe(clients, subscription{selector: ByID("c"), channels: []string{"y"}})
+	if !reflect.DeepEqual([]string{"z"}, clients.clients[0].channels) {
+		t.Errorf("expected subscription to a full channel to be refused\ngot:\n%q\n", clients.clients[0].channels)
+	}
+}
+
+func TestLimiterRemoteIP(t *testing.T) {
+	l := newLimiter(Limits{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		ip         string
+	}{
+		{"1.1.1.1:80", "", "1.1.1.1"},
+		{"1.1.1.1:80", "2.2.2.2", "1.1.1.1"},
+		{"10.0.0.1:80", "2.2.2.2", "2.2.2.2"},
+		{"10.0.0.1:80", "3.3.3.3, 2.2.2.2, 10.0.0.2", "2.2.2.2"},
+		{"10.0.0.1:80", "10.0.0.3", "10.0.0.3"},
+		{"10.0.0.1:80", "", "10.0.0.1"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		result := l.remoteIP(req)
+		if result != test.ip {
+			t.Errorf("expected:\n%s\ngot:\n%s\n", test.ip, result)
+		}
+	}
+}
+
+func TestServerAddEvictedClient(t *testing.T) {
+	s := server{
+		add:     make(chan client),
+		remove:  make(chan client),
+		metrics: NoopMetrics{},
+		limiter: newLimiter(Limits{MaxClientsPerIdentity: 1, EvictOldest: true}),
+	}
+	go s.listen()
+	s.limiter.admit(ticket{id: "a", identity: "alice"})
+	s.limiter.admit(ticket{id: "b", identity: "alice"})
+
+	read, write := net.Pipe()
+	c := client{
+		id:       "a",
+		identity: "alice",
+		events:   make(chan payload, 1),
+		done:     make(chan bool),
+		quit:     make(chan []byte, 1),
+		conn:     write,
+	}
+	s.add <- c
+	checkRead(t, read, CloseEvent{Reason: TooManyConnectionsError}.Bytes(), nil)
+	<-c.done
+	if _, ok := s.limiter.tickets["a"]; ok {
+		t.Errorf("expected evicted client to be released")
+	}
+}
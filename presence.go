@@ -0,0 +1,141 @@
+package eventsource
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// MemberJoinedEvent is the name of the event sent to a presence channel
+	// when a user joins it, with the user identity as data.
+	MemberJoinedEvent = "member-joined"
+
+	// MemberLeftEvent is the name of the event sent to a presence channel when
+	// a user leaves it, with the user identity as data.
+	MemberLeftEvent = "member-left"
+)
+
+// A presence tracks the identities subscribed to presence channels, counting
+// their clients so users with many connections join once and leave with their
+// last client. Only clients with an identity, subscribed to the channel by its
+// name and not through a wildcard subscription, are members. The server
+// updates it from its listen loop while Members reads it from any goroutine,
+// so a presence is safe for concurrent use. A nil presence tracks nothing.
+type presence struct {
+	patterns []string
+	mu       sync.RWMutex
+	members  map[string]map[string]int
+}
+
+// newPresence returns a presence tracking the channels matching the patterns,
+// or nil if there are no patterns.
+func newPresence(patterns []string) *presence {
+	if len(patterns) == 0 {
+		return nil
+	}
+	return &presence{
+		patterns: patterns,
+		members:  make(map[string]map[string]int),
+	}
+}
+
+// flagged returns true if the channel is a presence channel.
+func (p *presence) flagged(channel string) bool {
+	if _, wildcard := parsePattern(channel); wildcard {
+		return false
+	}
+	for _, pattern := range p.patterns {
+		if MatchChannel(pattern, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// join adds a client of the identity to the presence channels, returning the
+// channels the identity wasn't a member of.
+func (p *presence) join(identity string, channels []string) []string {
+	if p == nil || identity == "" {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var joined []string
+	for _, name := range channels {
+		if !p.flagged(name) {
+			continue
+		}
+		members := p.members[name]
+		if members == nil {
+			members = make(map[string]int)
+			p.members[name] = members
+		}
+		members[identity]++
+		if members[identity] == 1 {
+			joined = append(joined, name)
+		}
+	}
+	return joined
+}
+
+// leave removes a client of the identity from the presence channels, returning
+// the channels the identity has no clients left in.
+func (p *presence) leave(identity string, channels []string) []string {
+	if p == nil || identity == "" {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var left []string
+	for _, name := range channels {
+		members, ok := p.members[name]
+		if !ok || members[identity] == 0 {
+			continue
+		}
+		members[identity]--
+		if members[identity] == 0 {
+			delete(members, identity)
+			left = append(left, name)
+		}
+		if len(members) == 0 {
+			delete(p.members, name)
+		}
+	}
+	return left
+}
+
+// list returns the sorted identities of the members of a channel.
+func (p *presence) list(channel string) []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	members := make([]string, 0, len(p.members[channel]))
+	for identity := range p.members[channel] {
+		members = append(members, identity)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// announce sends a presence event about the identity to each channel. Clients
+// whose queue is full miss the event but are not disconnected, the next event
+// applies the overflow policy to them.
+func (s server) announce(clients *pool, name, identity string, channels []string) {
+	for _, channel := range channels {
+		e := DefaultEvent{Name: name, Message: []byte(identity), Channels: []string{channel}}
+		s.send(e, clients)
+	}
+}
+
+// difference returns the channels in a that are not in b.
+func difference(a, b []string) []string {
+	var diff []string
+	for _, name := range a {
+		if !contains(b, name) {
+			diff = append(diff, name)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,77 @@
+package eventsource
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPresenceJoinLeave(t *testing.T) {
+	p := newPresence([]string{"table.*"})
+	joined := p.join("alice", []string{"lobby", "table.1", "table.*"})
+	if !reflect.DeepEqual([]string{"table.1"}, joined) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"table.1"}, joined)
+	}
+	if joined := p.join("alice", []string{"table.1"}); len(joined) > 0 {
+		t.Errorf("expected second client not to join again\ngot:\n%q\n", joined)
+	}
+	p.join("bob", []string{"table.1"})
+	p.join("", []string{"table.1"})
+	if !reflect.DeepEqual([]string{"alice", "bob"}, p.list("table.1")) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"alice", "bob"}, p.list("table.1"))
+	}
+
+	if left := p.leave("alice", []string{"table.1"}); len(left) > 0 {
+		t.Errorf("expected user with clients left to stay\ngot:\n%q\n", left)
+	}
+	left := p.leave("alice", []string{"lobby", "table.1"})
+	if !reflect.DeepEqual([]string{"table.1"}, left) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"table.1"}, left)
+	}
+	if !reflect.DeepEqual([]string{"bob"}, p.list("table.1")) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"bob"}, p.list("table.1"))
+	}
+}
+
+func TestPresenceNil(t *testing.T) {
+	p := newPresence(nil)
+	if p != nil {
+		t.Fatalf("expected no presence without patterns")
+	}
+	if joined := p.join("alice", []string{"a"}); joined != nil {
+		t.Errorf("expected:\nnil\ngot:\n%q\n", joined)
+	}
+	if members := p.list("a"); members != nil {
+		t.Errorf("expected:\nnil\ngot:\n%q\n", members)
+	}
+}
+
+func TestServerPresence(t *testing.T) {
+	s := server{presence: newPresence([]string{"table.1"})}
+	c1 := client{id: "a", identity: "alice", channels: []string{"table.1"}, events: make(chan payload, 2), done: make(chan bool), quit: make(chan []byte, 1), conn: noopConn{}}
+	c2 := client{id: "b", identity: "bob", channels: []string{"table.1"}, events: make(chan payload, 2), done: make(chan bool), quit: make(chan []byte, 1), conn: noopConn{}}
+	clients := newPool()
+	clients.add(c1)
+	s.presence.join(c1.identity, c1.channels)
+	s.spawn(clients, c2)
+
+	joined := DefaultEvent{Name: MemberJoinedEvent, Message: []byte("bob"), Channels: []string{"table.1"}}
+	p := <-c1.events
+	if !reflect.DeepEqual(joined.Bytes(), p.data) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", joined.Bytes(), p.data)
+	}
+
+	s.evict(clients, c2, nil, Kicked)
+	left := DefaultEvent{Name: MemberLeftEvent, Message: []byte("bob"), Channels: []string{"table.1"}}
+	p = <-c1.events
+	if !reflect.DeepEqual(left.Bytes(), p.data) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", left.Bytes(), p.data)
+	}
+	if !reflect.DeepEqual([]string{"alice"}, s.presence.list("table.1")) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"alice"}, s.presence.list("table.1"))
+	}
+
+	s.subscribe(clients, subscription{selector: ByID("a"), channels: []string{"table.1"}, remove: true})
+	if members := s.presence.list("table.1"); len(members) > 0 {
+		t.Errorf("expected unsubscribed user to leave\ngot:\n%q\n", members)
+	}
+}
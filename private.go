@@ -0,0 +1,196 @@
+package eventsource
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PrivatePrefix is the default prefix of channels that require a signed token.
+const PrivatePrefix = "private-"
+
+var (
+	// MissingTokenError is displayed when a client subscribes to private
+	// channels without a channel token.
+	MissingTokenError = "missing channel token"
+
+	// InvalidTokenError is displayed when a channel token is malformed, has an
+	// invalid signature or has expired.
+	InvalidTokenError = "invalid channel token"
+
+	// PrivateChannelError is displayed when a channel token doesn't cover a
+	// private channel the client subscribes to.
+	PrivateChannelError = "private channel not allowed"
+)
+
+// PrivateChannels implements the Authorizer interface by requiring a signed
+// token from clients subscribing to private channels, the ones starting with
+// the prefix. Tokens are minted by the application with Token, covering the
+// principal the token is given to and the channels it can subscribe to, and
+// are sent by the browser in the querystring. Eg.:
+// /?channels=lobby,private-table.1&token=...
+// Wildcard subscriptions whose first token is a wildcard can match private
+// channels, so they require a token covering them as well.
+type PrivateChannels struct {
+	// Secret is the HMAC key tokens are signed with.
+	Secret []byte
+
+	// Authorizer authenticates the user before channels are checked. The
+	// principal ID it returns must be the one the token was minted for. It
+	// defaults to NoAuthorization.
+	Authorizer
+
+	// Prefix of private channels. It defaults to PrivatePrefix.
+	Prefix string
+
+	// Param is the querystring parameter holding the token. It defaults to
+	// "token".
+	Param string
+}
+
+// A channelToken is the signed content of a token.
+type channelToken struct {
+	Principal string   `json:"sub"`
+	Channels  []string `json:"channels"`
+	Expires   int64    `json:"exp,omitempty"`
+}
+
+// Token mints a token allowing the principal with the id to subscribe to the
+// channels, which can be wildcard subscriptions. A zero expires time makes a
+// token that never expires.
+func (p PrivateChannels) Token(principal string, channels []string, expires time.Time) string {
+	t := channelToken{Principal: principal, Channels: channels}
+	if !expires.IsZero() {
+		t.Expires = expires.Unix()
+	}
+	data, _ := json.Marshal(t)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload))
+}
+
+// Authorize authenticates the user, then checks that the token sent covers
+// every private channel.
+func (p PrivateChannels) Authorize(req *http.Request, channels []string) (Principal, error) {
+	authorizer := p.Authorizer
+	if authorizer == nil {
+		authorizer = NoAuthorization{}
+	}
+	principal, err := authorizer.Authorize(req, channels)
+	if err != nil {
+		return principal, err
+	}
+
+	var private []string
+	for _, name := range channels {
+		if p.private(name) {
+			private = append(private, name)
+		}
+	}
+	if len(private) == 0 {
+		return principal, nil
+	}
+
+	param := p.Param
+	if param == "" {
+		param = "token"
+	}
+	raw := req.URL.Query().Get(param)
+	if raw == "" {
+		return principal, Unauthorized(MissingTokenError)
+	}
+	t, ok := p.verify(raw)
+	if !ok || (t.Expires > 0 && time.Now().Unix() >= t.Expires) {
+		return principal, Unauthorized(InvalidTokenError)
+	}
+	if t.Principal != principal.ID {
+		return principal, Forbidden(PrivateChannelError)
+	}
+	for _, name := range private {
+		if !covers(t.Channels, name) {
+			return principal, Forbidden(PrivateChannelError)
+		}
+	}
+	return principal, nil
+}
+
+// private returns true if subscribing to the channel requires a token.
+func (p PrivateChannels) private(name string) bool {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = PrivatePrefix
+	}
+	first, _, _ := strings.Cut(name, ".")
+	return strings.HasPrefix(name, prefix) || first == "*" || first == ">"
+}
+
+// sign returns the HMAC-SHA256 signature of the payload.
+func (p PrivateChannels) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, p.Secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verify checks the token signature, returning its content.
+func (p PrivateChannels) verify(raw string) (channelToken, bool) {
+	var t channelToken
+	payload, signature, ok := strings.Cut(raw, ".")
+	if !ok {
+		return t, false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, p.sign(payload)) {
+		return t, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return t, false
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, false
+	}
+	return t, true
+}
+
+// covers returns true if one of the allowed channels is the channel or a
+// pattern matching it. A wildcard subscription is only covered by a pattern
+// matching every channel it matches, see narrows.
+func covers(allowed []string, name string) bool {
+	_, wildcard := parsePattern(name)
+	for _, a := range allowed {
+		if a == name {
+			return true
+		}
+		if wildcard && narrows(a, name) {
+			return true
+		}
+		if !wildcard && MatchChannel(a, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// narrows returns true if every channel matched by the subscription is also
+// matched by the pattern: literal tokens must be equal, "*" covers a literal
+// or "*" token and ">" covers one or more tokens of any kind. A "*" never
+// covers ">", which matches deeper channels.
+func narrows(pattern, subscription string) bool {
+	for {
+		token, rest, more := strings.Cut(pattern, ".")
+		name, next, ok := strings.Cut(subscription, ".")
+		if token == ">" {
+			return !more && subscription != ""
+		}
+		if name == ">" || (token != "*" && token != name) {
+			return false
+		}
+		if !more || !ok {
+			return !more && !ok
+		}
+		pattern, subscription = rest, next
+	}
+}
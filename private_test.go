@@ -0,0 +1,96 @@
+package eventsource
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPrivateChannelsPublic(t *testing.T) {
+	p := PrivateChannels{Secret: []byte("secret")}
+	req, _ := http.NewRequest("GET", "/?channels=lobby", nil)
+	if _, err := p.Authorize(req, []string{"lobby", "table.1"}); err != nil {
+		t.Errorf("expected public channels to be allowed\ngot:\n%s\n", err)
+	}
+}
+
+func TestPrivateChannelsToken(t *testing.T) {
+	p := PrivateChannels{
+		Secret: []byte("secret"),
+		Authorizer: authorizerFunc(func(*http.Request, []string) (Principal, error) {
+			return Principal{ID: "alice"}, nil
+		}),
+	}
+	valid := p.Token("alice", []string{"private-table.*"}, time.Now().Add(time.Minute))
+	other := PrivateChannels{Secret: []byte("other")}.Token("alice", []string{"private-table.1"}, time.Time{})
+	tests := []struct {
+		token    string
+		channels []string
+		code     int
+	}{
+		{valid, []string{"lobby", "private-table.1"}, 0},
+		{"", []string{"private-table.1"}, http.StatusUnauthorized},
+		{"garbage", []string{"private-table.1"}, http.StatusUnauthorized},
+		{other, []string{"private-table.1"}, http.StatusUnauthorized},
+		{valid[:len(valid)-2], []string{"private-table.1"}, http.StatusUnauthorized},
+		{p.Token("alice", []string{"private-table.1"}, time.Now().Add(-time.Second)), []string{"private-table.1"}, http.StatusUnauthorized},
+		{p.Token("bob", []string{"private-table.1"}, time.Time{}), []string{"private-table.1"}, http.StatusForbidden},
+		{valid, []string{"private-admin"}, http.StatusForbidden},
+		{valid, []string{">"}, http.StatusForbidden},
+		{p.Token("alice", []string{">"}, time.Time{}), []string{">"}, 0},
+		{valid, []string{"private-table.*"}, 0},
+		{valid, []string{"private-table.>"}, http.StatusForbidden},
+		{valid, []string{"private-table.*.secret"}, http.StatusForbidden},
+		{p.Token("alice", []string{"private-table.>"}, time.Time{}), []string{"private-table.*.secret"}, 0},
+		{p.Token("alice", []string{"private-table.>"}, time.Time{}), []string{"private-table.>"}, 0},
+		{p.Token("alice", []string{"private-*.1"}, time.Time{}), []string{"private-table.*"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/?token="+url.QueryEscape(test.token), nil)
+		principal, err := p.Authorize(req, test.channels)
+		if test.code == 0 {
+			if err != nil {
+				t.Errorf("unexpected error for %q: %s", test.channels, err)
+			}
+			if principal.ID != "alice" {
+				t.Errorf("expected:\nalice\ngot:\n%s\n", principal.ID)
+			}
+			continue
+		}
+		checkStatus(t, err, test.code)
+	}
+}
+
+func TestPrivateChannelsPrefix(t *testing.T) {
+	p := PrivateChannels{Secret: []byte("secret"), Prefix: "secret-", Param: "t"}
+	token := p.Token("", []string{"secret-a"}, time.Time{})
+	req, _ := http.NewRequest("GET", "/?t="+token, nil)
+	if _, err := p.Authorize(req, []string{"secret-a", "private-b"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestNarrows(t *testing.T) {
+	tests := []struct {
+		pattern      string
+		subscription string
+		expecting    bool
+	}{
+		{"table.*", "table.*", true},
+		{"table.*", "table.>", false},
+		{"table.>", "table.*", true},
+		{"table.>", "table.*.chat", true},
+		{"table.>", "table.>", true},
+		{"table.*", "*.*", false},
+		{"*.*", "table.*", true},
+		{"table.*.chat", "table.*.>", false},
+		{">", "table.>", true},
+		{"table.*", "table.*.chat", false},
+	}
+	for _, test := range tests {
+		if result := narrows(test.pattern, test.subscription); result != test.expecting {
+			t.Errorf("expected %q narrows %q to be %t\ngot:\n%t\n", test.pattern, test.subscription, test.expecting, result)
+		}
+	}
+}
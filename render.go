@@ -0,0 +1,87 @@
+package eventsource
+
+import "sync"
+
+// A Renderer is an event whose data depends on the client receiving it, so a
+// single broadcast can show each client only what it may see, eg. hiding the
+// cards of the other players. Renderers are checked when the event is sent to
+// local clients; the history and other nodes receive the data returned by
+// Bytes, which should be the rendering safe for anyone.
+//
+// RenderKey and Render are called from the goroutines writing to the clients,
+// not from the server, so a slow render only delays the clients waiting for
+// it, but they must be safe for concurrent use. A Renderer embedding a
+// built-in event, such as DefaultEvent, is sent to the subscribers of its
+// channels like the event it embeds.
+type Renderer interface {
+	Event
+
+	// RenderKey returns the key of the data rendered for the client, usually
+	// derived from its identity or principal. Clients with the same key
+	// receive the same data, rendered only once per send.
+	RenderKey(Client) string
+
+	// Render returns the text/stream data written to the client, see Fields.
+	Render(Client) []byte
+}
+
+// A rendering holds the data of an event being sent, rendering it once for
+// each key when the event is a Renderer. It is safe for concurrent use.
+type rendering struct {
+	renderer Renderer
+	data     []byte
+	mu       sync.Mutex
+	cache    map[string]*render
+}
+
+// A render is the data rendered for a key, rendered by the first client
+// needing it while other clients with the same key wait.
+type render struct {
+	once sync.Once
+	data []byte
+}
+
+// newRendering returns the rendering of the event, unwrapping events sent to a
+// client or a user. The data of events that aren't renderers is computed once,
+// right away.
+func newRendering(e Event) *rendering {
+	if r, ok := unwrap(e).(Renderer); ok {
+		return &rendering{renderer: r, cache: make(map[string]*render)}
+	}
+	return &rendering{data: e.Bytes()}
+}
+
+// payload returns the data written to the client, or the function rendering
+// it when the event is a Renderer.
+func (r *rendering) payload(c client) ([]byte, func() []byte) {
+	if r.renderer == nil {
+		return r.data, nil
+	}
+	view := c.view()
+	return nil, func() []byte { return r.bytes(view) }
+}
+
+// bytes returns the data rendered for the client.
+func (r *rendering) bytes(view Client) []byte {
+	key := r.renderer.RenderKey(view)
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	if !ok {
+		cached = &render{}
+		r.cache[key] = cached
+	}
+	r.mu.Unlock()
+	cached.once.Do(func() { cached.data = r.renderer.Render(view) })
+	return cached.data
+}
+
+// unwrap returns the event sent to a client or a user.
+func unwrap(e Event) Event {
+	switch e := e.(type) {
+	case direct:
+		return unwrap(e.event)
+	case personal:
+		return unwrap(e.event)
+	}
+	return e
+}
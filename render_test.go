@@ -0,0 +1,132 @@
+package eventsource
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// handEvent shows each player their own hand and hides the others.
+type handEvent struct {
+	DefaultEvent
+	hands   map[string]string
+	renders *int
+}
+
+func (e handEvent) RenderKey(c Client) string {
+	return c.Identity()
+}
+
+func (e handEvent) Render(c Client) []byte {
+	*e.renders++
+	hand, ok := e.hands[c.Identity()]
+	if !ok {
+		hand = "hidden"
+	}
+	return Fields{Event: "deal", Data: []byte(hand)}.Bytes()
+}
+
+func TestSendRenderer(t *testing.T) {
+	renders := 0
+	e := handEvent{
+		DefaultEvent: DefaultEvent{Message: []byte("hidden")},
+		hands:        map[string]string{"alice": "AK", "bob": "QQ"},
+		renders:      &renders,
+	}
+	c1 := client{id: "1", identity: "alice", events: make(chan payload, 1)}
+	c2 := client{id: "2", identity: "bob", events: make(chan payload, 1)}
+	c3 := client{id: "3", identity: "alice", events: make(chan payload, 1)}
+	c4 := client{id: "4", events: make(chan payload, 1)}
+	s := server{}
+	s.send(e, poolOf(c1, c2, c3, c4))
+	if renders != 0 {
+		t.Errorf("expected events to be rendered by the clients, not the server")
+	}
+
+	expecting := []string{"AK", "QQ", "AK", "hidden"}
+	var result []string
+	for _, c := range []client{c1, c2, c3, c4} {
+		data := (<-c.events).bytes()
+		result = append(result, string(data[len("event: deal\ndata: "):len(data)-2]))
+	}
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, result)
+	}
+	if renders != 3 {
+		t.Errorf("expected:\n3 renders\ngot:\n%d\n", renders)
+	}
+}
+
+func TestSendRendererToUser(t *testing.T) {
+	renders := 0
+	e := handEvent{hands: map[string]string{"bob": "QQ"}, renders: &renders}
+	c := client{id: "1", identity: "bob", events: make(chan payload, 1)}
+	s := server{}
+	s.send(personal{event: e, identity: "bob"}, poolOf(c))
+
+	expecting := Fields{Event: "deal", Data: []byte("QQ")}.Bytes()
+	if result := (<-c.events).bytes(); !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, result)
+	}
+}
+
+func TestSendRendererRouted(t *testing.T) {
+	renders := 0
+	e := handEvent{
+		DefaultEvent: DefaultEvent{Channels: []string{"table.1"}},
+		hands:        map[string]string{"alice": "AK"},
+		renders:      &renders,
+	}
+	if channels, ok := route(e); !ok || !reflect.DeepEqual([]string{"table.1"}, channels) {
+		t.Errorf("expected renderer to be routed by the event it embeds\ngot:\n%q %t\n", channels, ok)
+	}
+	c1 := client{id: "1", identity: "alice", channels: []string{"table.*"}, events: make(chan payload, 1)}
+	c2 := client{id: "2", identity: "bob", channels: []string{"table.2"}, events: make(chan payload, 1)}
+	s := server{}
+	_, size, _ := s.send(e, poolOf(c1, c2))
+	if size != 1 || len(c2.events) > 0 {
+		t.Fatalf("expected only the subscriber to receive the event\ngot:\n%d clients\n", size)
+	}
+	expecting := Fields{Event: "deal", Data: []byte("AK")}.Bytes()
+	if result := (<-c1.events).bytes(); !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, result)
+	}
+}
+
+func TestRenderingConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	renders := 0
+	e := countingRenderer{render: func() {
+		mu.Lock()
+		renders++
+		mu.Unlock()
+	}}
+	r := newRendering(e)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, render := r.payload(client{identity: "alice"})
+			render()
+		}()
+	}
+	wg.Wait()
+	if renders != 1 {
+		t.Errorf("expected:\n1 render\ngot:\n%d\n", renders)
+	}
+}
+
+type countingRenderer struct {
+	DefaultEvent
+	render func()
+}
+
+func (e countingRenderer) RenderKey(c Client) string {
+	return c.Identity()
+}
+
+func (e countingRenderer) Render(Client) []byte {
+	e.render()
+	return nil
+}
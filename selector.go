@@ -0,0 +1,79 @@
+package eventsource
+
+// A Selector chooses connected clients by returning true for the ones that
+// must be affected by an operation, such as Eventsource.Subscribe.
+type Selector func(Client) bool
+
+// ByID selects the client with the id passed in.
+func ByID(id string) Selector {
+	return func(c Client) bool {
+		return c.id == id
+	}
+}
+
+// ByIdentity selects the clients of the user with the identity passed in. See
+// IdentityResolver.
+func ByIdentity(identity string) Selector {
+	return func(c Client) bool {
+		return identity != "" && c.identity == identity
+	}
+}
+
+// ByHeader selects clients whose initial http request had the header key set
+// to value. Eg.: ByHeader("X-User-ID", "42")
+func ByHeader(key, value string) Selector {
+	return func(c Client) bool {
+		return c.header.Get(key) == value
+	}
+}
+
+// ByQuery selects clients whose initial http request had the querystring
+// param key set to value. Eg.: ByQuery("user", "42")
+func ByQuery(key, value string) Selector {
+	return func(c Client) bool {
+		return c.query.Get(key) == value
+	}
+}
+
+// A kick disconnects the clients selected, writing data to them first.
+type kick struct {
+	selector Selector
+	data     []byte
+}
+
+// A subscription adds or removes channels of the clients selected.
+type subscription struct {
+	selector Selector
+	channels []string
+	remove   bool
+}
+
+// apply returns the new channels list of a client after the subscription. The
+// list passed in is never modified since it can be shared with client views.
+func (s subscription) apply(channels []string) []string {
+	updated := make([]string, 0, len(channels)+len(s.channels))
+	for _, c := range channels {
+		if !s.remove || !contains(s.channels, c) {
+			updated = append(updated, c)
+		}
+	}
+	if s.remove {
+		return updated
+	}
+	for _, c := range s.channels {
+		if !contains(updated, c) {
+			updated = append(updated, c)
+		}
+	}
+	return updated
+}
+
+// contains returns true if the list has the value passed in.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
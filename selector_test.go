@@ -0,0 +1,76 @@
+package eventsource
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestByID(t *testing.T) {
+	sel := ByID("a")
+	if !sel(Client{id: "a"}) {
+		t.Errorf("expected client a to be selected")
+	}
+	if sel(Client{id: "b"}) {
+		t.Errorf("expected client b not to be selected")
+	}
+}
+
+func TestByHeader(t *testing.T) {
+	sel := ByHeader("X-User-ID", "42")
+	header := http.Header{}
+	header.Set("X-User-ID", "42")
+	if !sel(Client{header: header}) {
+		t.Errorf("expected client with header to be selected")
+	}
+	if sel(Client{header: http.Header{}}) {
+		t.Errorf("expected client without header not to be selected")
+	}
+}
+
+func TestByQuery(t *testing.T) {
+	sel := ByQuery("user", "42")
+	if !sel(Client{query: url.Values{"user": {"42"}}}) {
+		t.Errorf("expected client with query to be selected")
+	}
+	if sel(Client{query: url.Values{"user": {"1"}}}) {
+		t.Errorf("expected client with another user not to be selected")
+	}
+}
+
+func TestSubscriptionApplyAdd(t *testing.T) {
+	channels := []string{"a", "b"}
+	sub := subscription{channels: []string{"b", "c"}}
+	expecting := []string{"a", "b", "c"}
+	result := sub.apply(channels)
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\nto be equal to:\n%q\n", expecting, result)
+	}
+}
+
+func TestSubscriptionApplyRemove(t *testing.T) {
+	channels := []string{"a", "b", "c"}
+	sub := subscription{channels: []string{"b"}, remove: true}
+	expecting := []string{"a", "c"}
+	result := sub.apply(channels)
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\nto be equal to:\n%q\n", expecting, result)
+	}
+	if !reflect.DeepEqual([]string{"a", "b", "c"}, channels) {
+		t.Errorf("expected channels not to be modified\ngot:\n%q\n", channels)
+	}
+}
+
+func TestByIdentity(t *testing.T) {
+	sel := ByIdentity("42")
+	if !sel(Client{identity: "42"}) {
+		t.Errorf("expected client of user 42 to be selected")
+	}
+	if sel(Client{identity: "1"}) {
+		t.Errorf("expected client of user 1 not to be selected")
+	}
+	if ByIdentity("")(Client{}) {
+		t.Errorf("expected anonymous clients not to be selected")
+	}
+}
@@ -1,6 +1,10 @@
 package eventsource
 
-import "time"
+import (
+	"log"
+	"sync"
+	"time"
+)
 
 // A server manages all clients, adding and removing them from the pool and
 // receiving incoming events to forward to clients
@@ -8,99 +12,284 @@ type server struct {
 	add      chan client
 	remove   chan client
 	events   chan Event
+	subs     chan subscription
+	kicks    chan kick
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce *sync.Once
 	hearbeat time.Duration
 	metrics  Metrics
+	store    EventStore
+	overflow OverflowPolicy
+	limiter  *limiter
+	presence *presence
+
+	lifecycle      *lifecycle
+	onChannelOpen  func(string)
+	onChannelClose func(string)
+
+	onConnect    func(Client)
+	onDisconnect func(Client, DisconnectReason)
+	dispatcher   *dispatcher
 }
 
 // The listen method is used to receive messages to add, remove and send
 // events to clients. Every X seconds it sends a ping message to all clients to
-// detect stale connections
+// detect stale connections. The latest RetryEvent is written to new clients
+// before their backlog. Closing the stop channel makes the server flush
+// pending events, disconnect all clients and return.
 func (s server) listen() {
-	var clients []client
-	tick := time.Tick(s.hearbeat)
+	clients := newPool()
+	var tick <-chan time.Time
+	if s.hearbeat > 0 {
+		ticker := time.NewTicker(s.hearbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var retry []byte
+	var vacated <-chan closing
+	if s.lifecycle != nil {
+		vacated = s.lifecycle.timeouts
+	}
 	for {
 		select {
 		case c := <-s.add:
-			clients = s.spawn(clients, c)
+			c.backlog = append(c.backlog, s.replay(c)...)
+			if retry != nil {
+				c.backlog = append([][]byte{retry}, c.backlog...)
+			}
+			s.spawn(clients, c)
+			if s.limiter.evicted(c.id) {
+				s.evict(clients, c, CloseEvent{Reason: TooManyConnectionsError}.Bytes(), Kicked)
+			}
 		case c := <-s.remove:
-			clients = s.kill(clients, c)
+			if clients.has(c) {
+				s.kill(clients, c, c.reason)
+			}
+			c.drain()
 		case e := <-s.events:
+			s.record(e)
+			if r, ok := e.(RetryEvent); ok && r.Retry > 0 {
+				retry = r.Bytes()
+			}
+			start := time.Now()
+			p, size, overflowed := s.send(e, clients)
+			s.disconnect(clients, overflowed)
 			go func() {
-				start := time.Now()
-				durations := send(e, clients)
+				durations := p.wait(size)
 				s.metrics.EventDone(e, time.Since(start), durations)
 			}()
+		case sub := <-s.subs:
+			s.subscribe(clients, sub)
+		case k := <-s.kicks:
+			s.kick(clients, k)
+		case c := <-vacated:
+			if s.lifecycle.expire(c) {
+				s.closed([]string{c.channel})
+			}
 		case <-tick:
-			go func() {
-				durations := send(ping{}, clients)
-				s.metrics.ClientCount(len(durations))
-			}()
+			_, size, overflowed := s.send(ping{}, clients)
+			s.disconnect(clients, overflowed)
+			go s.metrics.ClientCount(size)
+		case <-s.stop:
+			s.shutdown(clients)
+			return
 		}
 	}
 }
 
-// send receives an event and a list of clients and send to them the
-// text/stream data to be written on the client's connection. It returns a list
-// of time.Duration each client took. 0 duration means that the data wasn't
-// sent.
-func send(e Event, clients []client) []time.Duration {
-	durations := []time.Duration{}
-	clients = e.Clients(clients)
-	size := len(clients)
-	if size == 0 {
-		return durations
+// shutdown closes every client events channel so clients write their queued
+// events and close their connections. It keeps draining the remove channel
+// while waiting, since clients with a failed write block until the server
+// receives their removal. The stopped channel is closed once every client has
+// finished and every hook has run. Channels still open are closed.
+func (s server) shutdown(clients *pool) {
+	for _, c := range clients.clients {
+		close(c.events)
+		s.limiter.release(c.id)
+		s.disconnected(c, Shutdown)
 	}
-	done := make(chan time.Duration, size)
-	p := payload{data: e.Bytes(), done: done}
-
-	for _, c := range clients {
-		go func(c client) {
+	for _, c := range clients.clients {
+		for waiting := true; waiting; {
 			select {
-			case c.events <- p:
 			case <-c.done:
-				p.done <- 0
+				waiting = false
+			case removed := <-s.remove:
+				removed.drain()
 			}
-		}(c)
+		}
 	}
+	s.closed(s.lifecycle.all())
+	s.dispatcher.close()
+	close(s.stopped)
+}
 
-	for i := 0; i < size; i++ {
-		d := <-done
-		durations = append(durations, d)
+// record appends recordable events to the event store, if the server has one.
+// Store errors are logged and don't prevent the event from being sent.
+func (s server) record(e Event) {
+	if s.store == nil {
+		return
+	}
+	r, ok := e.(Recordable)
+	if !ok {
+		return
+	}
+	if err := s.store.Append(r.Record()); err != nil {
+		log.Printf("Event store append failed - %s\n", err)
 	}
-	return durations
 }
 
-// The spawn adds a new client to the clients list and launches a goroutine for
-// the client to listen to incoming messages. The client receives the remove
-// channel necessary to unsubscribe itself from the server.
-func (s server) spawn(clients []client, c client) []client {
-	go c.listen(s.remove)
-	clients = append(clients, c)
-	return clients
+// replay returns the data of the events a new client missed since the last
+// event id it received, if any.
+func (s server) replay(c client) [][]byte {
+	var backlog [][]byte
+	for _, r := range s.since(c) {
+		backlog = append(backlog, r.Data)
+	}
+	return backlog
+}
+
+// prefetch reads the backlog of a new client before it joins, so the listen
+// loop doesn't wait on the event store. The client last event id becomes the
+// id of the last event read, so once the client joins the server only replays
+// the events recorded in between.
+func (s server) prefetch(c client) client {
+	records := s.since(c)
+	for _, r := range records {
+		c.backlog = append(c.backlog, r.Data)
+	}
+	if len(records) > 0 {
+		c.lastEventID = records[len(records)-1].ID
+	}
+	return c
+}
+
+// since returns the records a new client missed since the last event id it
+// received, if any.
+func (s server) since(c client) []Record {
+	if s.store == nil || c.lastEventID == "" {
+		return nil
+	}
+	records, err := s.store.Since(c.lastEventID, c.channels)
+	if err != nil {
+		log.Printf("Event store replay failed - %s\n", err)
+		return nil
+	}
+	return records
 }
 
-// The kill removes a client from the client list by comparing their events
-// channel. The client is removed by being moved to the end of the list and
-// reducing the slice length.
-func (s server) kill(clients []client, client client) []client {
-	index := -1
-	for i, c := range clients {
-		if client.events == c.events {
-			index = i
-			break
+// send puts the event text/stream data on the queue of every client that must
+// receive it, without blocking. It returns the payload, whose done channel
+// receives how long each client took to write it, the number of clients the
+// event was sent to and the clients that must be disconnected because their
+// queue is full. Renderer events are rendered for each client, see Renderer.
+func (s server) send(e Event, clients *pool) (payload, int, []client) {
+	targets := clients.match(e)
+	size := len(targets)
+	_, heartbeat := e.(ping)
+	p := payload{done: make(chan time.Duration, size), heartbeat: heartbeat}
+	r := newRendering(e)
+	var overflowed []client
+	for _, c := range targets {
+		p.data, p.render = r.payload(c)
+		if !c.enqueue(p, s.overflow) {
+			overflowed = append(overflowed, c)
 		}
 	}
+	return p, size, overflowed
+}
 
-	if index == -1 {
-		panic("client not found")
+// subscribe updates the channels of the clients selected by the subscription,
+// their presence in presence channels and the channels lifecycle. Clients
+// that would go over the channel limits keep their channels.
+func (s server) subscribe(clients *pool, sub subscription) {
+	for i, c := range clients.clients {
+		if sub.selector(c.view()) {
+			channels := sub.apply(c.channels)
+			if err := s.limiter.resubscribe(c.id, channels); err != nil {
+				log.Printf("Client %s subscription refused - %s\n", c.id, err)
+				continue
+			}
+			clients.update(i, channels)
+			left := s.presence.leave(c.identity, difference(c.channels, channels))
+			s.announce(clients, MemberLeftEvent, c.identity, left)
+			joined := s.presence.join(c.identity, difference(channels, c.channels))
+			s.announce(clients, MemberJoinedEvent, c.identity, joined)
+			s.closed(s.lifecycle.vacate(difference(c.channels, channels)))
+			s.opened(s.lifecycle.open(difference(channels, c.channels)))
+		}
 	}
+}
 
-	last := len(clients) - 1
-	if index < last {
-		swap := clients[last]
-		clients[index] = swap
+// targets returns the clients matched by the event, calling Match for each
+// client.
+func targets(e Event, clients []client) []client {
+	var selected []client
+	for _, c := range clients {
+		if e.Match(c.view()) {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// disconnect removes the clients passed in from the pool, telling them to
+// close their connection immediately.
+func (s server) disconnect(clients *pool, disconnected []client) {
+	for _, c := range disconnected {
+		s.evict(clients, c, nil, Overflowed)
 	}
-	clients = clients[:last]
+}
 
-	return clients
+// kick disconnects the clients selected, writing the kick final data before
+// closing their connections.
+func (s server) kick(clients *pool, k kick) {
+	var selected []client
+	for _, c := range clients.clients {
+		if k.selector(c.view()) {
+			selected = append(selected, c)
+		}
+	}
+	for _, c := range selected {
+		s.evict(clients, c, k.data, Kicked)
+	}
+}
+
+// evict removes a client from the pool, discarding its queued events, and
+// tells the client to write the final data and close its connection.
+func (s server) evict(clients *pool, c client, final []byte, reason DisconnectReason) {
+	s.kill(clients, c, reason)
+	c.drain()
+	c.quit <- final
+}
+
+// The spawn adds a new client to the pool and launches a goroutine for the
+// client to listen to incoming messages. The client receives the remove
+// channel necessary to unsubscribe itself from the server. Presence channels
+// the client user wasn't a member of are told the user joined, and channels
+// without subscribers are opened.
+func (s server) spawn(clients *pool, c client) {
+	go c.listen(s.remove)
+	clients.add(c)
+	s.connected(c)
+	s.opened(s.lifecycle.open(c.channels))
+	joined := s.presence.join(c.identity, c.channels)
+	s.announce(clients, MemberJoinedEvent, c.identity, joined)
+}
+
+// The kill removes a client from the pool, panicking if the client is not in
+// the pool. The client stops counting towards the limits, the OnDisconnect
+// hook runs with the reason passed in and presence channels the client user
+// has no clients left in are told the user left. Channels left without
+// subscribers are closed.
+func (s server) kill(clients *pool, c client, reason DisconnectReason) {
+	c, ok := clients.remove(c)
+	if !ok {
+		panic("client not found")
+	}
+	s.limiter.release(c.id)
+	s.disconnected(c, reason)
+	left := s.presence.leave(c.identity, c.channels)
+	s.announce(clients, MemberLeftEvent, c.identity, left)
+	s.closed(s.lifecycle.vacate(c.channels))
 }
@@ -2,6 +2,8 @@ package eventsource
 
 import (
 	"bytes"
+	"io"
+	"net"
 	"reflect"
 	"testing"
 	"time"
@@ -49,8 +51,10 @@ func TestServerPing(t *testing.T) {
 func TestServerSpawn(t *testing.T) {
 	s := server{}
 	c := client{}
+	clients := newPool()
 	expecting := []client{c}
-	result := s.spawn([]client{}, c)
+	s.spawn(clients, c)
+	result := clients.clients
 	if !reflect.DeepEqual(expecting, result) {
 		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
 	}
@@ -61,7 +65,9 @@ func TestServerKill(t *testing.T) {
 	c1 := client{events: make(chan payload)}
 	c2 := client{events: make(chan payload)}
 	expecting := []client{c2}
-	result := s.kill([]client{c1, c2}, c1)
+	clients := poolOf(c1, c2)
+	s.kill(clients, c1, WriteError)
+	result := clients.clients
 	if !reflect.DeepEqual(expecting, result) {
 		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
 	}
@@ -76,14 +82,14 @@ func TestServerKillPanic(t *testing.T) {
 			t.Errorf("expected function to panic, it did not\n")
 		}
 	}()
-	s.kill([]client{c2}, c1)
+	s.kill(poolOf(c2), c1, WriteError)
 }
 
 func TestSendPayload(t *testing.T) {
 	e := DefaultEvent{Message: message}
-	c := client{events: make(chan payload)}
-	go c.listen(make(chan client))
-	go send(e, []client{c})
+	c := client{events: make(chan payload, 1)}
+	s := server{}
+	s.send(e, poolOf(c))
 	p := <-c.events
 
 	expecting := e.Bytes()
@@ -98,7 +104,9 @@ func TestSendMetrics(t *testing.T) {
 	e := DefaultEvent{Message: message}
 	c := stubTCPClient()
 	go c.listen(make(chan client))
-	result := send(e, []client{c})
+	s := server{}
+	p, size, _ := s.send(e, poolOf(c))
+	result := p.wait(size)
 	if len(result) != 1 {
 		t.Errorf("expected:\n1 duration\ngot:\n%v\n", len(result))
 	}
@@ -107,11 +115,205 @@ func TestSendMetrics(t *testing.T) {
 func TestSendError(t *testing.T) {
 	e := DefaultEvent{Message: message}
 	c := stubTCPClient()
-	close(c.done)
-	result := send(e, []client{c})
+	c.events <- payload{}
+	s := server{overflow: DropNewest}
+	p, size, _ := s.send(e, poolOf(c))
+	result := p.wait(size)
 
 	expecting := []time.Duration{0}
 	if !reflect.DeepEqual(expecting, result) {
 		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
 	}
 }
+
+func TestSendOverflowDisconnect(t *testing.T) {
+	e := DefaultEvent{Message: message}
+	c1 := client{events: make(chan payload, 1), quit: make(chan []byte, 1)}
+	c2 := client{events: make(chan payload, 1), quit: make(chan []byte, 1)}
+	c1.events <- payload{}
+	s := server{overflow: DisconnectClient}
+	clients := poolOf(c1, c2)
+	_, _, overflowed := s.send(e, clients)
+
+	expecting := []client{c1}
+	if !reflect.DeepEqual(expecting, overflowed) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, overflowed)
+	}
+
+	s.disconnect(clients, overflowed)
+	if !reflect.DeepEqual([]client{c2}, clients.clients) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", []client{c2}, clients.clients)
+	}
+	if final := <-c1.quit; final != nil {
+		t.Errorf("expected client to quit without final data\ngot:\n%s\n", final)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := server{
+		add:     make(chan client),
+		remove:  make(chan client),
+		events:  make(chan Event),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		metrics: NoopMetrics{},
+	}
+	read, write := net.Pipe()
+	c := client{events: make(chan payload, 1), done: make(chan bool), conn: write}
+	go s.listen()
+	s.add <- c
+	go func() {
+		s.events <- DefaultEvent{Message: message}
+		close(s.stop)
+	}()
+	expecting := DefaultEvent{Message: message}.Bytes()
+	checkRead(t, read, expecting, nil)
+	select {
+	case <-s.stopped:
+	case <-time.After(1 * time.Second):
+		t.Errorf("expected server to stop")
+	}
+	checkRead(t, read, nil, io.EOF)
+}
+
+func TestServerReplay(t *testing.T) {
+	s := server{store: NewMemoryStore(10)}
+	e1 := DefaultEvent{ID: "1", Message: message}
+	e2 := DefaultEvent{ID: "2", Message: message, Channels: []string{"a"}}
+	e3 := DefaultEvent{ID: "3", Message: message, Channels: []string{"b"}}
+	for _, e := range []Event{e1, e2, e3} {
+		s.record(e)
+	}
+	c := client{lastEventID: "1", channels: []string{"a"}}
+
+	expecting := [][]byte{e2.Bytes()}
+	result := s.replay(c)
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\nto be equal to:\n%q\n", expecting, result)
+	}
+}
+
+func TestServerReplayWithoutHistory(t *testing.T) {
+	s := server{}
+	c := client{lastEventID: "1"}
+	result := s.replay(c)
+	if len(result) > 0 {
+		t.Errorf("expected:\n%q\nto be empty\n", result)
+	}
+}
+
+type idEvent struct {
+	DefaultEvent
+	id string
+}
+
+func (e idEvent) Match(c Client) bool {
+	return c.ID() == e.id
+}
+
+func TestSendCustomEvent(t *testing.T) {
+	e := idEvent{DefaultEvent{Message: message}, "b"}
+	c1 := client{id: "a", events: make(chan payload, 1)}
+	c2 := client{id: "b", events: make(chan payload, 1)}
+	s := server{}
+	_, size, _ := s.send(e, poolOf(c1, c2))
+	if size != 1 {
+		t.Errorf("expected:\n1 client\ngot:\n%d\n", size)
+	}
+	if len(c2.events) != 1 {
+		t.Errorf("expected event to be sent to client b")
+	}
+}
+
+func TestServerSubscribe(t *testing.T) {
+	s := server{}
+	c1 := client{id: "a", channels: []string{"x"}, events: make(chan payload)}
+	c2 := client{id: "b", channels: []string{"x"}, events: make(chan payload)}
+	clients := poolOf(c1, c2)
+	s.subscribe(clients, subscription{selector: ByID("b"), channels: []string{"y"}})
+
+	if !reflect.DeepEqual([]string{"x"}, clients.clients[0].channels) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"x"}, clients.clients[0].channels)
+	}
+	if !reflect.DeepEqual([]string{"x", "y"}, clients.clients[1].channels) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", []string{"x", "y"}, clients.clients[1].channels)
+	}
+	result := clients.match(DefaultEvent{Channels: []string{"y"}})
+	if len(result) != 1 || result[0].id != "b" {
+		t.Errorf("expected index to route y to client b\ngot:\n%v\n", result)
+	}
+}
+
+func TestServerSubscribeChannel(t *testing.T) {
+	s := server{
+		add:     make(chan client),
+		events:  make(chan Event),
+		subs:    make(chan subscription),
+		metrics: NoopMetrics{},
+	}
+	read, write := net.Pipe()
+	c := client{id: "a", events: make(chan payload, 1), done: make(chan bool), conn: write}
+	go s.listen()
+	s.add <- c
+	s.subs <- subscription{selector: ByID("a"), channels: []string{"y"}}
+	e := DefaultEvent{Message: message, Channels: []string{"y"}}
+	s.events <- e
+	checkRead(t, read, e.Bytes(), nil)
+}
+
+func TestServerKick(t *testing.T) {
+	s := server{add: make(chan client), kicks: make(chan kick)}
+	read, write := net.Pipe()
+	c1 := client{
+		id:     "a",
+		events: make(chan payload, 1),
+		done:   make(chan bool),
+		quit:   make(chan []byte, 1),
+		conn:   write,
+	}
+	c2 := client{id: "b", events: make(chan payload, 1), quit: make(chan []byte, 1)}
+	go s.listen()
+	s.add <- c1
+	s.add <- c2
+	final := CloseEvent{Reason: "banned"}.Bytes()
+	s.kicks <- kick{selector: ByID("a"), data: final}
+
+	checkRead(t, read, final, nil)
+	checkRead(t, read, nil, io.EOF)
+	if len(c2.quit) > 0 {
+		t.Errorf("expected client b to be kept")
+	}
+}
+
+func TestServerRetry(t *testing.T) {
+	s := server{
+		add:     make(chan client),
+		events:  make(chan Event),
+		metrics: NoopMetrics{},
+	}
+	go s.listen()
+	s.events <- RetryEvent{Retry: 30000}
+	read, write := net.Pipe()
+	c := client{events: make(chan payload), done: make(chan bool), conn: write}
+	s.add <- c
+	checkRead(t, read, RetryEvent{Retry: 30000}.Bytes(), nil)
+}
+
+func TestServerPrefetch(t *testing.T) {
+	s := server{store: NewMemoryStore(10)}
+	e1 := DefaultEvent{ID: "1", Message: message}
+	e2 := DefaultEvent{ID: "2", Message: message}
+	e3 := DefaultEvent{ID: "3", Message: message}
+	s.record(e1)
+	s.record(e2)
+	c := s.prefetch(client{lastEventID: "1"})
+	if !reflect.DeepEqual([][]byte{e2.Bytes()}, c.backlog) || c.lastEventID != "2" {
+		t.Errorf("expected backlog up to event 2\ngot:\n%q %s\n", c.backlog, c.lastEventID)
+	}
+
+	s.record(e3)
+	expecting := [][]byte{e3.Bytes()}
+	if result := s.replay(c); !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%q\nto be equal to:\n%q\n", expecting, result)
+	}
+}
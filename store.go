@@ -0,0 +1,128 @@
+package eventsource
+
+import (
+	"sort"
+	"sync"
+)
+
+// An EventStore keeps records of the events sent, so clients reconnecting with
+// a Last-Event-ID header can receive the events they missed. This package has
+// two built-in implementations: MemoryStore and FileStore, but you can
+// implement your own.
+type EventStore interface {
+	// Append stores a record. Records without an ID can't be replayed and may
+	// be ignored.
+	Append(Record) error
+
+	// Since returns, in the order they were appended, the records after the
+	// one with the id passed in that a client subscribed to channels should
+	// receive. If the id is unknown it returns no records.
+	Since(id string, channels []string) ([]Record, error)
+}
+
+// MemoryStore implements the EventStore interface keeping the last events in
+// memory. It holds up to size events for each channel and size global events.
+type MemoryStore struct {
+	mu       sync.Mutex
+	size     int
+	seq      uint64
+	global   []entry
+	channels map[string][]entry
+	ids      map[string]uint64
+	refs     map[uint64]int
+}
+
+// An entry is a record with its position in the store.
+type entry struct {
+	seq uint64
+	Record
+}
+
+// NewMemoryStore returns a MemoryStore keeping size events for each channel
+// and size global events.
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{
+		size:     size,
+		channels: make(map[string][]entry),
+		ids:      make(map[string]uint64),
+		refs:     make(map[uint64]int),
+	}
+}
+
+// Append adds a record to the global buffer if it has no channels, or to the
+// buffer of each of its channels, evicting the oldest entries when a buffer
+// is full. Records without an ID are ignored.
+func (m *MemoryStore) Append(r Record) error {
+	if r.ID == "" || m.size <= 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	e := entry{seq: m.seq, Record: r}
+	m.ids[r.ID] = e.seq
+	if len(r.Channels) == 0 {
+		m.global = m.push(m.global, e)
+		return nil
+	}
+	for _, name := range r.Channels {
+		m.channels[name] = m.push(m.channels[name], e)
+	}
+	return nil
+}
+
+// push appends an entry to a buffer, removing the oldest one if the buffer
+// exceeds the store size.
+func (m *MemoryStore) push(buf []entry, e entry) []entry {
+	m.refs[e.seq]++
+	buf = append(buf, e)
+	if len(buf) <= m.size {
+		return buf
+	}
+	old := buf[0]
+	m.refs[old.seq]--
+	if m.refs[old.seq] == 0 {
+		delete(m.refs, old.seq)
+		if m.ids[old.ID] == old.seq {
+			delete(m.ids, old.ID)
+		}
+	}
+	copy(buf, buf[1:])
+	return buf[:len(buf)-1]
+}
+
+// Since returns, in the order they were added, all records after the one with
+// the id passed in that a client subscribed to the channels passed in should
+// receive. If the id is unknown, either because it was never sent or it was
+// already evicted, nothing is returned.
+func (m *MemoryStore) Since(id string, channels []string) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last, ok := m.ids[id]
+	if !ok {
+		return nil, nil
+	}
+	var entries []entry
+	seen := make(map[uint64]bool)
+	collect := func(buf []entry) {
+		i := sort.Search(len(buf), func(i int) bool { return buf[i].seq > last })
+		for _, e := range buf[i:] {
+			if !seen[e.seq] {
+				seen[e.seq] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	collect(m.global)
+	for name, buf := range m.channels {
+		if subscribes(channels, []string{name}) {
+			collect(buf)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	records := make([]Record, len(entries))
+	for i, e := range entries {
+		records[i] = e.Record
+	}
+	return records, nil
+}
@@ -0,0 +1,106 @@
+package eventsource
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemoryStoreSince(t *testing.T) {
+	m := NewMemoryStore(10)
+	r1 := Record{ID: "1", Data: []byte("1")}
+	r2 := Record{ID: "2", Channels: []string{"a"}, Data: []byte("2")}
+	r3 := Record{ID: "3", Channels: []string{"b"}, Data: []byte("3")}
+	r4 := Record{ID: "4", Data: []byte("4")}
+	for _, r := range []Record{r1, r2, r3, r4} {
+		m.Append(r)
+	}
+
+	expecting := []Record{r2, r4}
+	result, _ := m.Since("1", []string{"a"})
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestMemoryStoreSinceMultipleChannels(t *testing.T) {
+	m := NewMemoryStore(10)
+	r1 := Record{ID: "1", Data: []byte("1")}
+	r2 := Record{ID: "2", Channels: []string{"a", "b"}, Data: []byte("2")}
+	m.Append(r1)
+	m.Append(r2)
+
+	expecting := []Record{r2}
+	result, _ := m.Since("1", []string{"a", "b"})
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestMemoryStoreSinceUnknownID(t *testing.T) {
+	m := NewMemoryStore(10)
+	m.Append(Record{ID: "1", Data: []byte("1")})
+	result, _ := m.Since("0", nil)
+	if len(result) > 0 {
+		t.Errorf("expected:\n%v\nto be empty\n", result)
+	}
+}
+
+func TestMemoryStoreAddWithoutID(t *testing.T) {
+	m := NewMemoryStore(10)
+	m.Append(Record{ID: "1", Data: []byte("1")})
+	m.Append(Record{Data: []byte("2")})
+	result, _ := m.Since("1", nil)
+	if len(result) > 0 {
+		t.Errorf("expected:\n%v\nto be empty\n", result)
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	m := NewMemoryStore(2)
+	r1 := Record{ID: "1", Data: []byte("1")}
+	r2 := Record{ID: "2", Data: []byte("2")}
+	r3 := Record{ID: "3", Data: []byte("3")}
+	m.Append(r1)
+	m.Append(r2)
+	m.Append(r3)
+
+	if result, _ := m.Since("1", nil); len(result) > 0 {
+		t.Errorf("expected evicted id to replay nothing\ngot:\n%v\n", result)
+	}
+	expecting := []Record{r3}
+	result, _ := m.Since("2", nil)
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestMemoryStoreEvictionPerChannel(t *testing.T) {
+	m := NewMemoryStore(1)
+	r1 := Record{ID: "1", Channels: []string{"a", "b"}, Data: []byte("1")}
+	r2 := Record{ID: "2", Channels: []string{"a"}, Data: []byte("2")}
+	m.Append(r1)
+	m.Append(r2)
+
+	expecting := []Record{r2}
+	result, _ := m.Since("1", []string{"a"})
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
+
+func TestMemoryStoreSinceWildcards(t *testing.T) {
+	m := NewMemoryStore(10)
+	r1 := Record{ID: "1", Data: []byte("1")}
+	r2 := Record{ID: "2", Channels: []string{"table.1"}, Data: []byte("2")}
+	r3 := Record{ID: "3", Channels: []string{"lobby"}, Data: []byte("3")}
+	r4 := Record{ID: "4", Channels: []string{"table.2"}, Data: []byte("4")}
+	for _, r := range []Record{r1, r2, r3, r4} {
+		m.Append(r)
+	}
+
+	expecting := []Record{r2, r4}
+	result, _ := m.Since("1", []string{"table.*"})
+	if !reflect.DeepEqual(expecting, result) {
+		t.Errorf("expected:\n%v\nto be equal to:\n%v\n", expecting, result)
+	}
+}
@@ -0,0 +1,86 @@
+package eventsource
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// A stream is what a client writes events to. It is implemented by net.Conn
+// for hijacked connections and by flushWriter for streamed responses.
+type stream interface {
+	io.WriteCloser
+	SetWriteDeadline(time.Time) error
+}
+
+// A flushWriter implements the stream interface on top of a
+// http.ResponseWriter, flushing every write so events reach the browser
+// immediately.
+type flushWriter struct {
+	res     http.ResponseWriter
+	flusher http.Flusher
+	control *http.ResponseController
+}
+
+func newFlushWriter(res http.ResponseWriter, flusher http.Flusher) *flushWriter {
+	return &flushWriter{
+		res:     res,
+		flusher: flusher,
+		control: http.NewResponseController(res),
+	}
+}
+
+// Write writes data to the response and flushes it.
+func (w *flushWriter) Write(data []byte) (int, error) {
+	n, err := w.res.Write(data)
+	if err != nil {
+		return n, err
+	}
+	w.flusher.Flush()
+	return n, nil
+}
+
+// SetWriteDeadline sets the underlining connection write deadline, if the
+// response writer supports it.
+func (w *flushWriter) SetWriteDeadline(t time.Time) error {
+	err := w.control.SetWriteDeadline(t)
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+// Close clears the write deadline so the server can finish the response. The
+// response itself ends when the handler returns.
+func (w *flushWriter) Close() error {
+	return w.SetWriteDeadline(time.Time{})
+}
+
+// hopHeaders are connection specific headers that can't be set on a response
+// writer, in particular over HTTP/2.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Transfer-Encoding"}
+
+// writeOptions parses the raw http response returned by HttpOptions, copying
+// its status and headers to the response writer and writing its body, so any
+// HttpOptions implementation can be used without hijacking the connection.
+func writeOptions(res http.ResponseWriter, req *http.Request, options []byte) error {
+	reader := bufio.NewReader(bytes.NewReader(options))
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	header := res.Header()
+	for k, v := range resp.Header {
+		header[k] = v
+	}
+	for _, k := range hopHeaders {
+		header.Del(k)
+	}
+	res.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(res, resp.Body)
+	return err
+}
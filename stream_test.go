@@ -0,0 +1,58 @@
+package eventsource
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteOptions(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("origin", "http://localhost/")
+	options := DefaultHttpOptions{Cors: true, Retry: 2000}
+	err := writeOptions(w, req, options.Bytes(req))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if w.Code != 200 {
+		t.Errorf("expected:\n200\ngot:\n%d\n", w.Code)
+	}
+	expecting := "text/event-stream"
+	if result := w.Header().Get("Content-Type"); expecting != result {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+	expecting = "http://localhost/"
+	if result := w.Header().Get("Access-Control-Allow-Origin"); expecting != result {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+	if result := w.Header().Get("Connection"); result != "" {
+		t.Errorf("expected Connection header to be removed\ngot:\n%s\n", result)
+	}
+	body := []byte("retry: 2000\n\n")
+	if result := w.Body.Bytes(); !bytes.Equal(body, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", body, result)
+	}
+}
+
+func TestFlushWriterWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+	fw := newFlushWriter(w, w)
+	expecting := []byte("data: test\n\n")
+	fw.Write(expecting)
+	if !w.Flushed {
+		t.Errorf("expected response to be flushed")
+	}
+	if result := w.Body.Bytes(); !bytes.Equal(expecting, result) {
+		t.Errorf("expected:\n%s\ngot:\n%s\n", expecting, result)
+	}
+}
+
+func TestFlushWriterSetWriteDeadlineNotSupported(t *testing.T) {
+	fw := newFlushWriter(httptest.NewRecorder(), httptest.NewRecorder())
+	if err := fw.Close(); err != nil {
+		t.Errorf("expected unsupported deadline to be ignored\ngot:\n%s\n", err)
+	}
+}
@@ -0,0 +1,300 @@
+package eventsource
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPeerQueueSize is the number of messages a TCPBroker holds for a
+	// peer it can't reach before discarding new ones.
+	DefaultPeerQueueSize = 1024
+
+	// dedupSize is the number of message ids a TCPBroker remembers to discard
+	// duplicates.
+	dedupSize = 1 << 14
+
+	peerWriteTimeout = 5 * time.Second
+	maxPeerBackoff   = 5 * time.Second
+)
+
+// TCPBroker implements the Broker interface as a mesh of TCP connections
+// between a static list of nodes. Each node listens on its own address and
+// sends every message straight to each peer, as JSON documents, one per line.
+// Connections to peers are dialed when needed and dialed again, with backoff,
+// when they fail; a message being written when a connection fails is written
+// again, so peers discard messages they have already received. The list of
+// peers can include the node itself. A TCPBroker is also a Forwarder, sending
+// messages to a single peer; a Cluster using it needs the HTTP address of
+// each node, see Cluster.Addrs.
+//
+// Connections are only accepted from the hosts of the peers, but there is no
+// authentication nor TLS: anyone able to connect from those hosts, or to
+// spoof them, can send events to every client. The broker address must only
+// be reachable from a trusted network.
+type TCPBroker struct {
+	listener net.Listener
+	peers    []*peer
+	seen     *dedup
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	handler func(Message)
+	conns   map[net.Conn]bool
+	closed  bool
+}
+
+// A peer is a node messages are sent to, through its queue.
+type peer struct {
+	addr  string
+	queue chan Message
+}
+
+// NewTCPBroker listens on addr for messages from other nodes and starts
+// sending messages to the peers.
+func NewTCPBroker(addr string, peers []string) (*TCPBroker, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b := &TCPBroker{
+		listener: listener,
+		seen:     newDedup(dedupSize),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]bool),
+	}
+	for _, addr := range peers {
+		p := &peer{addr: addr, queue: make(chan Message, DefaultPeerQueueSize)}
+		b.peers = append(b.peers, p)
+		b.wg.Add(1)
+		go b.send(p)
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// Addr returns the address the broker listens on.
+func (b *TCPBroker) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// Publish queues the message to every peer. Messages to peers whose queue is
+// full are discarded.
+func (b *TCPBroker) Publish(m Message) error {
+	b.seen.add(m.ID)
+	for _, p := range b.peers {
+		select {
+		case p.queue <- m:
+		default:
+			log.Printf("Broker peer %s queue full - message discarded\n", p.addr)
+		}
+	}
+	return nil
+}
+
+// Forward queues the message to the peer with the address passed in,
+// implementing the Forwarder interface.
+func (b *TCPBroker) Forward(node string, m Message) error {
+	for _, p := range b.peers {
+		if p.addr != node {
+			continue
+		}
+		select {
+		case p.queue <- m:
+			return nil
+		default:
+			return fmt.Errorf("eventsource: peer %s queue full", node)
+		}
+	}
+	return fmt.Errorf("eventsource: unknown peer %s", node)
+}
+
+// Subscribe sets the handler called for each message received from a peer.
+func (b *TCPBroker) Subscribe(handler func(Message)) {
+	b.mu.Lock()
+	b.handler = handler
+	b.mu.Unlock()
+}
+
+// Close stops listening and closes every connection, discarding queued
+// messages.
+func (b *TCPBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	err := b.listener.Close()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return err
+}
+
+// accept receives connections from peers until the broker is closed.
+func (b *TCPBroker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !b.peer(conn.RemoteAddr()) {
+			log.Printf("Broker connection from %s refused - not a peer\n", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = true
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go b.receive(conn)
+	}
+}
+
+// peer returns true if the remote address is one of the IPs the hosts of the
+// peers resolve to. Hosts are resolved for each connection, so peers can
+// change IPs.
+func (b *TCPBroker) peer(remote net.Addr) bool {
+	addr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, p := range b.peers {
+		host, _, err := net.SplitHostPort(p.addr)
+		if err != nil {
+			continue
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(addr.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// receive reads messages from a peer connection, calling the handler for the
+// ones not seen before.
+func (b *TCPBroker) receive(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var m Message
+		if err := decoder.Decode(&m); err != nil {
+			return
+		}
+		if !b.seen.add(m.ID) {
+			continue
+		}
+		b.mu.Lock()
+		handler := b.handler
+		b.mu.Unlock()
+		if handler != nil {
+			handler(m)
+		}
+	}
+}
+
+// send writes the queued messages to a peer, dialing it again when the
+// connection fails.
+func (b *TCPBroker) send(p *peer) {
+	defer b.wg.Done()
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	backoff := 100 * time.Millisecond
+	for {
+		var m Message
+		select {
+		case m = <-p.queue:
+		case <-b.done:
+			return
+		}
+		for {
+			if conn == nil {
+				c, err := net.DialTimeout("tcp", p.addr, peerWriteTimeout)
+				if err != nil {
+					select {
+					case <-time.After(backoff):
+					case <-b.done:
+						return
+					}
+					if backoff *= 2; backoff > maxPeerBackoff {
+						backoff = maxPeerBackoff
+					}
+					continue
+				}
+				conn = c
+				backoff = 100 * time.Millisecond
+			}
+			line, _ := json.Marshal(m)
+			conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+			if _, err := conn.Write(append(line, '\n')); err != nil {
+				log.Printf("Broker peer %s write failed - %s\n", p.addr, err)
+				conn.Close()
+				conn = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+// A dedup remembers the last ids it was given. It is safe for concurrent use.
+type dedup struct {
+	mu   sync.Mutex
+	ids  map[string]bool
+	ring []string
+	next int
+}
+
+func newDedup(size int) *dedup {
+	return &dedup{ids: make(map[string]bool), ring: make([]string, size)}
+}
+
+// add remembers an id, forgetting the oldest one if needed. It returns false
+// if the id was already known.
+func (d *dedup) add(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ids[id] {
+		return false
+	}
+	if old := d.ring[d.next]; old != "" {
+		delete(d.ids, old)
+	}
+	d.ring[d.next] = id
+	d.next = (d.next + 1) % len(d.ring)
+	d.ids[id] = true
+	return true
+}
@@ -0,0 +1,97 @@
+package eventsource
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	d := newDedup(2)
+	if !d.add("a") || !d.add("b") {
+		t.Fatalf("expected new ids to be added")
+	}
+	if d.add("a") {
+		t.Errorf("expected duplicate id to be discarded")
+	}
+	d.add("c")
+	if !d.add("a") {
+		t.Errorf("expected oldest id to be forgotten")
+	}
+}
+
+func TestTCPBroker(t *testing.T) {
+	l1, _ := net.Listen("tcp", "127.0.0.1:0")
+	l2, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr1, addr2 := l1.Addr().String(), l2.Addr().String()
+	l1.Close()
+	l2.Close()
+	peers := []string{addr1, addr2}
+
+	b1, err := NewTCPBroker(addr1, peers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer b1.Close()
+	b2, err := NewTCPBroker(addr2, peers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer b2.Close()
+
+	received1 := make(chan Message, 2)
+	received2 := make(chan Message, 2)
+	b1.Subscribe(func(m Message) { received1 <- m })
+	b2.Subscribe(func(m Message) { received2 <- m })
+
+	b1.Publish(Message{ID: "a", Data: []byte("data: 1\n\n")})
+	select {
+	case m := <-received2:
+		if m.ID != "a" || string(m.Data) != "data: 1\n\n" {
+			t.Errorf("expected:\na\ngot:\n%v\n", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected message to reach the peer")
+	}
+
+	b2.Publish(Message{ID: "a"})
+	b2.Publish(Message{ID: "b"})
+	select {
+	case m := <-received1:
+		if m.ID != "b" {
+			t.Errorf("expected duplicate to be discarded\ngot:\n%v\n", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected message to reach the peer")
+	}
+	if len(received2) > 0 {
+		t.Errorf("expected node not to receive its own messages")
+	}
+}
+
+func TestTCPBrokerRefusesStrangers(t *testing.T) {
+	b, err := NewTCPBroker("127.0.0.1:0", []string{"192.0.2.1:7000"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer b.Close()
+	received := make(chan Message, 1)
+	b.Subscribe(func(m Message) { received <- m })
+
+	conn, err := net.Dial("tcp", b.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"id":"a","data":"ZGF0YTogMQoK"}` + "\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); err == nil || (ok && ne.Timeout()) {
+		t.Errorf("expected connection from a stranger to be closed\ngot:\n%v\n", err)
+	}
+	select {
+	case m := <-received:
+		t.Errorf("expected message from a stranger to be discarded\ngot:\n%v\n", m)
+	default:
+	}
+}
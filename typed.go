@@ -0,0 +1,124 @@
+package eventsource
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// JSONEvent implements the Event interface for data encoded as JSON. The data
+// is encoded once, when the event is created, see NewJSONEvent, and the same
+// bytes are written to every client, recorded and published to other nodes.
+type JSONEvent struct {
+	ID       string
+	Name     string
+	Channels []string
+	Retry    int
+	data     []byte
+}
+
+// NewJSONEvent returns an event named name, sent to the channels passed in,
+// with the value passed in encoded as JSON. It returns the encoding error, if
+// any.
+func NewJSONEvent(name string, v interface{}, channels ...string) (JSONEvent, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return JSONEvent{}, err
+	}
+	return JSONEvent{Name: name, Channels: channels, data: data}, nil
+}
+
+// Data returns the JSON encoded data.
+func (e JSONEvent) Data() []byte {
+	return e.data
+}
+
+// Bytes returns the text/stream message with the JSON data, see Fields.
+func (e JSONEvent) Bytes() []byte {
+	return Fields{ID: e.ID, Event: e.Name, Data: e.data, Retry: e.Retry}.Bytes()
+}
+
+// Match selects clients subscribed to at least one of the event channels or
+// all clients if the event has no channel.
+func (e JSONEvent) Match(c Client) bool {
+	return DefaultEvent{Channels: e.Channels}.Match(c)
+}
+
+// channels returns the event channels, see routed.
+func (e JSONEvent) channels() []string {
+	return e.Channels
+}
+
+// Record returns the event as a Record to be kept in the history.
+func (e JSONEvent) Record() Record {
+	return Record{ID: e.ID, Channels: e.Channels, Data: e.Bytes()}
+}
+
+// EventID returns the event id.
+func (e JSONEvent) EventID() string {
+	return e.ID
+}
+
+// WithID returns a copy of the event with the id passed in.
+func (e JSONEvent) WithID(id string) Event {
+	e.ID = id
+	return e
+}
+
+// TypedEvent is a JSONEvent that keeps the value of type T it was created
+// from, see NewTypedEvent.
+type TypedEvent[T any] struct {
+	JSONEvent
+	Value T
+}
+
+// NewTypedEvent returns an event named name, sent to the channels passed in,
+// with the value passed in encoded as JSON.
+func NewTypedEvent[T any](name string, v T, channels ...string) (TypedEvent[T], error) {
+	e, err := NewJSONEvent(name, v, channels...)
+	if err != nil {
+		return TypedEvent[T]{}, err
+	}
+	return TypedEvent[T]{JSONEvent: e, Value: v}, nil
+}
+
+// WithID returns a copy of the event with the id passed in.
+func (e TypedEvent[T]) WithID(id string) Event {
+	e.ID = id
+	return e
+}
+
+// A Topic publishes values of type T as events with the same name and
+// channels, so the values sent under a name always have the same type:
+//
+//	scores := eventsource.NewTopic[Score](es, "score", "table.1")
+//	err := scores.Publish(ctx, Score{Player: "bob", Points: 10})
+type Topic[T any] struct {
+	es       *Eventsource
+	name     string
+	channels []string
+}
+
+// NewTopic returns a topic sending events named name to the channels passed
+// in, or to all clients if there's none, through the eventsource.
+func NewTopic[T any](es *Eventsource, name string, channels ...string) *Topic[T] {
+	return &Topic[T]{es: es, name: name, channels: channels}
+}
+
+// Name returns the name of the events published.
+func (t *Topic[T]) Name() string {
+	return t.name
+}
+
+// Publish encodes the value passed in as JSON and sends it as an event, see
+// Eventsource.Send. It returns the encoding error, if any, or the context
+// error if the context is done before the event is delivered.
+func (t *Topic[T]) Publish(ctx context.Context, v T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e, err := NewTypedEvent(t.name, v, t.channels...)
+	if err != nil {
+		return err
+	}
+	return t.es.send(ctx, e)
+}
@@ -0,0 +1,82 @@
+package eventsource
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type score struct {
+	Player string `json:"player"`
+	Points int    `json:"points"`
+}
+
+func TestJSONEventBytes(t *testing.T) {
+	e, err := NewJSONEvent("score", score{Player: "bob", Points: 10}, "table.1")
+	if err != nil {
+		t.Fatalf("expected event to be encoded\ngot:\n%s\n", err)
+	}
+	e.ID = "3"
+	expecting := []byte("id: 3\nevent: score\ndata: {\"player\":\"bob\",\"points\":10}\n\n")
+	if result := e.Bytes(); !bytes.Equal(expecting, result) {
+		t.Errorf("expected:\n%q\ngot:\n%q\n", expecting, result)
+	}
+	record := Record{ID: "3", Channels: []string{"table.1"}, Data: expecting}
+	if result := e.Record(); !reflect.DeepEqual(record, result) {
+		t.Errorf("expected:\n%v\ngot:\n%v\n", record, result)
+	}
+	if _, err := NewJSONEvent("fn", func() {}); err == nil {
+		t.Errorf("expected value that can't be encoded to return an error")
+	}
+}
+
+func TestTypedEventWithID(t *testing.T) {
+	e, _ := NewTypedEvent("score", score{Player: "bob"})
+	result, ok := e.WithID("7").(TypedEvent[score])
+	if !ok {
+		t.Fatalf("expected:\nTypedEvent[score]\ngot:\n%T\n", result)
+	}
+	if result.EventID() != "7" || result.Value.Player != "bob" {
+		t.Errorf("expected event to keep its value with the new id\ngot:\n%v\n", result)
+	}
+}
+
+func TestTopicPublish(t *testing.T) {
+	es := &Eventsource{}
+	events := make(chan Event, 1)
+	es.server = server{events: events}
+	scores := NewTopic[score](es, "score", "table.1")
+
+	if err := scores.Publish(context.Background(), score{Player: "bob", Points: 10}); err != nil {
+		t.Fatalf("expected event to be published\ngot:\n%s\n", err)
+	}
+	e, ok := (<-events).(TypedEvent[score])
+	if !ok {
+		t.Fatalf("expected a TypedEvent[score]\ngot:\n%T\n", e)
+	}
+	if e.Name != "score" || !reflect.DeepEqual([]string{"table.1"}, e.Channels) {
+		t.Errorf("expected event to have the topic name and channels\ngot:\n%v\n", e)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := scores.Publish(ctx, score{}); err != context.Canceled {
+		t.Errorf("expected:\n%s\ngot:\n%v\n", context.Canceled, err)
+	}
+	if len(events) > 0 {
+		t.Errorf("expected event not to be sent when the context is done")
+	}
+}
+
+func TestTopicPublishDeadline(t *testing.T) {
+	es := &Eventsource{}
+	es.server = server{events: make(chan Event)}
+	scores := NewTopic[score](es, "score")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := scores.Publish(ctx, score{}); err != context.DeadlineExceeded {
+		t.Errorf("expected:\n%s\ngot:\n%v\n", context.DeadlineExceeded, err)
+	}
+}